@@ -1,12 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/sujalshah-bit/DirectDrop/internal/config"
 	"github.com/sujalshah-bit/DirectDrop/internal/p2p"
+	"github.com/sujalshah-bit/DirectDrop/internal/qr"
 	"github.com/sujalshah-bit/DirectDrop/pkg"
 )
 
@@ -17,22 +29,58 @@ func main() {
 		os.Exit(1)
 	}
 
+	if policy := *flags[config.ON_CONFLICT]; !slices.Contains(p2p.ConflictPolicies, policy) {
+		log.Fatalf("OnConflict must be one of %s", strings.Join(p2p.ConflictPolicies, ", "))
+	}
+
 	action := *flags[config.ACTION]
 	if action == "share" {
-		serverAddress := pkg.GetDeviceIPWithPort("8081")
+		serverAddress := pkg.GetDeviceIPWithPort(*flags[config.PORT])
 		server := p2p.NewSharerTCPServer(serverAddress, *flags[config.SERVER_ADDRESS], config.TIMEOUT*time.Second, flags)
+		server.TLSConfig = pkg.ServerTLS(flags)
+		server.Words, _ = strconv.Atoi(*flags[config.WORDS])
+		server.MaxUses, _ = strconv.Atoi(*flags[config.USES])
+		server.Expires, _ = pkg.ParseExpiry(*flags[config.EXPIRES])
 		if err := server.Start(); err != nil {
 			log.Fatalf("Failed to start server: %v", err)
 		}
-		err := server.SendCodeToServer()
+		code, err := server.SendCodeToServer()
 		if err != nil {
-			log.Printf("Failed to send code: %v", err)
+			log.Fatalf("Failed to send code: %v", err)
+		}
+		if err := printCode(os.Stdout, code, server, flags); err != nil {
+			log.Printf("Failed to print code: %v", err)
+		}
+		server.StartRelay()
+		server.StartKeepAlive()
+		if addr := *flags[config.METRICS]; addr != "" {
+			go serveMetrics(addr)
 		}
-		defer server.Stop()
-		// Block main so server keeps running
-		select {}
+
+		// Keep sharing until the share closes by itself or is interrupted,
+		// and unregister the code either way.
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		select {
+		case <-server.Done():
+		case sig := <-signals:
+			log.Printf("Received %v, stopping share", sig)
+		}
+		server.Stop()
 	} else {
+		if addr := *flags[config.METRICS]; addr != "" {
+			go serveMetrics(addr)
+		}
 		receiever := p2p.NewTCPClient(*flags[config.SERVER_ADDRESS], config.TIMEOUT*time.Second)
+		receiever.TLSConfig = pkg.ServerTLS(flags)
+		receiever.TargetDir = *flags[config.OUT]
+		receiever.OnConflict = *flags[config.ON_CONFLICT]
+		receiever.NoPreserve = pkg.Enabled(flags[config.NO_PRESERVE])
+		stdin := bufio.NewReader(os.Stdin)
+		receiever.AskConflict = askConflict(stdin, os.Stdout)
+		if !pkg.Enabled(flags[config.YES]) {
+			receiever.Confirm = confirmShare(stdin, os.Stdout)
+		}
 		err := receiever.Connect()
 		if err != nil {
 			log.Printf("Receiver failed to connect to server: %v", err)
@@ -40,6 +88,10 @@ func main() {
 		}
 		defer receiever.Close()
 		sharerIP, err := receiever.ReceiveCode(*flags[config.CODE])
+		if errors.Is(err, p2p.ErrCodeNotFound) {
+			log.Printf("Code %s: %v", *flags[config.CODE], err)
+			os.Exit(1)
+		}
 		if err != nil {
 			log.Printf("Receiver failed to receiver info from server: %v", err)
 			os.Exit(1)
@@ -47,8 +99,153 @@ func main() {
 		}
 
 		log.Printf("Value received: %v", sharerIP)
-		receiever.RequestData(sharerIP)
+		err = receiever.RequestData(sharerIP)
+		if errors.Is(err, p2p.ErrDeclined) {
+			log.Printf("Transfer declined, nothing was written")
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Printf("Receiver failed to download data: %v", err)
+			os.Exit(1)
+		}
+
+	}
+
+}
+
+// confirmShare returns a p2p.TCPClient.Confirm that shows the manifest on out
+// and asks for a yes on in. Anything else, including in being closed or not
+// a terminal that answers, declines the share.
+func confirmShare(in *bufio.Reader, out io.Writer) func(*config.Manifest) bool {
+	return func(manifest *config.Manifest) bool {
+		printManifest(out, manifest)
+		fmt.Fprint(out, "Accept this share? [y/N] ")
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Fprintln(out, "\nNo answer, declining. Pass -Yes to accept shares without asking.")
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+}
+
+// askConflict returns a p2p.TCPClient.AskConflict that asks on in what to do
+// with a file that already exists. An empty answer renames the received file,
+// no answer at all skips it.
+func askConflict(in *bufio.Reader, out io.Writer) func(string) string {
+	return func(path string) string {
+		for {
+			fmt.Fprintf(out, "%s already exists. [o]verwrite, [s]kip or [R]ename? ", path)
+			answer, err := in.ReadString('\n')
+			if err != nil && answer == "" {
+				fmt.Fprintln(out, "\nNo answer, skipping it.")
+				return p2p.ConflictSkip
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "o", "overwrite":
+				return p2p.ConflictOverwrite
+			case "s", "skip":
+				return p2p.ConflictSkip
+			case "", "r", "rename":
+				return p2p.ConflictRename
+			}
+		}
+	}
+}
+
+// printManifest describes the share a sharer offers.
+func printManifest(w io.Writer, manifest *config.Manifest) {
+	if manifest == nil {
+		fmt.Fprintln(w, "\nThe sharer did not say what it is sending.")
+		return
+	}
+	fmt.Fprintf(w, "\nIncoming share: %s\n", manifest.Name)
+	if manifest.Dirs > 0 {
+		fmt.Fprintf(w, "  %d file(s) in %d folder(s), %s\n", manifest.Files, manifest.Dirs, formatSize(manifest.Size))
+	} else {
+		fmt.Fprintf(w, "  %d file(s), %s\n", manifest.Files, formatSize(manifest.Size))
+	}
+	for _, entry := range manifest.Entries {
+		fmt.Fprintf(w, "    %s\n", entry)
+	}
+	if manifest.More > 0 {
+		fmt.Fprintf(w, "    ... and %d more\n", manifest.More)
+	}
+}
+
+// formatSize renders n bytes with a binary unit, e.g. "1.5 MiB".
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	value, unit := float64(n)/1024, 0
+	for value >= 1024 && unit < 4 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[unit])
+}
 
+// serveMetrics serves the transfer metrics of the share on addr until the
+// process exits. A failure is logged but does not stop the share.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", p2p.Metrics.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Printf("Serving metrics on http://%s/metrics", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("Metrics endpoint failed: %v", err)
 	}
+}
+
+// printCode shows the share code on w, separate from the log output on
+// stderr. With -JSON a single JSON object is printed for scripts instead.
+func printCode(w io.Writer, code string, sharer *p2p.SharerTCPServer, flags []*string) error {
+	server := *flags[config.SERVER_ADDRESS]
 
+	if pkg.Enabled(flags[config.JSON]) {
+		var expires *time.Time
+		if !sharer.Expires.IsZero() {
+			expires = &sharer.Expires
+		}
+		return json.NewEncoder(w).Encode(struct {
+			Code    string     `json:"code"`
+			Address string     `json:"address"`
+			Server  string     `json:"server"`
+			Uses    int        `json:"uses,omitempty"`
+			Expires *time.Time `json:"expires,omitempty"`
+		}{code, sharer.AdvertiseAddr(), server, sharer.MaxUses, expires})
+	}
+
+	command := fmt.Sprintf("direct_drop -Action receive -Code %s -Address %s", code, server)
+	if fingerprint := *flags[config.FINGERPRINT]; fingerprint != "" {
+		command += " -Fingerprint " + fingerprint
+	} else if pkg.Enabled(flags[config.TLS]) {
+		command += " -TLS"
+	}
+	fmt.Fprintf(w, "\nShare code: %s\n", code)
+	if sharer.MaxUses > 0 {
+		fmt.Fprintf(w, "Closes after %d download(s)\n", sharer.MaxUses)
+	}
+	if !sharer.Expires.IsZero() {
+		fmt.Fprintf(w, "Expires at %s\n", sharer.Expires.Format(time.RFC1123))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "On the receiving side run:\n  %s\n\n", command)
+
+	if pkg.Enabled(flags[config.QR]) {
+		symbol, err := qr.Encode(code)
+		if err != nil {
+			return err
+		}
+		if err := symbol.Render(w); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
 }
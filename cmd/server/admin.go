@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+// Admin serves an HTTP/JSON API for operators: a health check, metrics,
+// server statistics, the active registrations and revoking a code.
+// Registrations are listed by their nameplate, the public part of the code;
+// addresses and owner tokens are never shown.
+type Admin struct {
+	Token string // required as a bearer token on /api/ requests when set
+
+	server  *rendezvous.Server
+	peers   *rendezvous.Peers
+	started time.Time
+}
+
+func NewAdmin(server *rendezvous.Server) *Admin {
+	return &Admin{server: server, peers: server.Peers(), started: time.Now()}
+}
+
+// Handler returns the HTTP handler of the admin API.
+func (a *Admin) Handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/stats", a.stats)
+	api.HandleFunc("GET /api/peers", a.listPeers)
+	api.HandleFunc("DELETE /api/peers/{code}", a.revokePeer)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", a.health)
+	mux.Handle("GET /metrics", registry.Handler())
+	mux.Handle("/api/", a.authorize(api))
+	return mux
+}
+
+// authorize rejects requests without the admin token, if one is set.
+func (a *Admin) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if a.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Admin) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "ok",
+		"uptime": Duration{time.Since(a.started).Round(time.Second)},
+	})
+}
+
+type statsResponse struct {
+	Uptime        Duration `json:"uptime"`
+	Registrations int      `json:"registrations"`
+	Available     int      `json:"available"` // registrations receivers can still look up
+	Connections   struct {
+		Active int64 `json:"active"`
+		Total  int64 `json:"total"`
+	} `json:"connections"`
+	Relay struct {
+		Enabled  bool `json:"enabled"`
+		Standbys int  `json:"standbys"`
+		Sessions int  `json:"sessions"`
+	} `json:"relay"`
+}
+
+func (a *Admin) stats(w http.ResponseWriter, r *http.Request) {
+	var stats statsResponse
+	stats.Uptime = Duration{time.Since(a.started).Round(time.Second)}
+	now := time.Now()
+	a.peers.Range(func(code string, peer rendezvous.PeerInfo) bool {
+		stats.Registrations++
+		if peer.Available(now) {
+			stats.Available++
+		}
+		return true
+	})
+	stats.Connections.Active, stats.Connections.Total = a.server.Connections()
+	if relay := a.server.Relay; relay != nil {
+		stats.Relay.Enabled = true
+		stats.Relay.Standbys = relay.Standbys()
+		stats.Relay.Sessions = relay.Sessions()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// registration describes a registration without revealing its address or
+// token.
+type registration struct {
+	Code      string    `json:"code"`
+	Age       Duration  `json:"age"`
+	LastSeen  time.Time `json:"lastSeen"`
+	MaxUses   int       `json:"maxUses,omitempty"`
+	Downloads int       `json:"downloads"`
+	Lookups   int       `json:"lookups"`
+	Expires   time.Time `json:"expires,omitzero"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+func (a *Admin) listPeers(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	list := []registration{}
+	a.peers.Range(func(code string, peer rendezvous.PeerInfo) bool {
+		list = append(list, registration{
+			Code:      code,
+			Age:       Duration{now.Sub(peer.Created).Round(time.Second)},
+			LastSeen:  peer.LastSeen,
+			MaxUses:   peer.MaxUses,
+			Downloads: peer.Downloads,
+			Lookups:   peer.Lookups,
+			Expires:   peer.Expires,
+			Revoked:   peer.Revoked,
+		})
+		return true
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *Admin) revokePeer(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	err := a.peers.Revoke(code)
+	if errors.Is(err, rendezvous.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no registration for code "+code)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if relay := a.server.Relay; relay != nil {
+		relay.Drop(code)
+	}
+	slog.Info("Revoked code", "code", code, "admin", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+func newPeers() *rendezvous.Peers {
+	return rendezvous.NewPeers(rendezvous.NewMemoryStore())
+}
+
+func startAdmin(t *testing.T, peers *rendezvous.Peers, token string) *httptest.Server {
+	t.Helper()
+	rs := rendezvous.NewServer(peers)
+	rs.Relay = rendezvous.NewRelay(0, time.Minute, time.Minute)
+	admin := NewAdmin(rs)
+	admin.Token = token
+	server := httptest.NewServer(admin.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func adminRequest(t *testing.T, method, url, token string, v any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decoding %s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminAPI(t *testing.T) {
+	peers := newPeers()
+	token, _ := peers.Add("7", rendezvous.PeerInfo{Address: "10.0.0.1:9000", MaxUses: 1})
+	peers.Add("8", rendezvous.PeerInfo{Address: "10.0.0.2:9000"})
+	peers.Refresh("7", token, 1)
+	server := startAdmin(t, peers, "s3cret")
+
+	if got := adminRequest(t, "GET", server.URL+"/healthz", "", nil); got != http.StatusOK {
+		t.Errorf("health check without token: got status %d", got)
+	}
+	if got := adminRequest(t, "GET", server.URL+"/api/peers", "", nil); got != http.StatusUnauthorized {
+		t.Errorf("listing without token: got status %d", got)
+	}
+	if got := adminRequest(t, "GET", server.URL+"/api/peers", "wrong", nil); got != http.StatusUnauthorized {
+		t.Errorf("listing with wrong token: got status %d", got)
+	}
+
+	var stats statsResponse
+	if got := adminRequest(t, "GET", server.URL+"/api/stats", "s3cret", &stats); got != http.StatusOK {
+		t.Fatalf("stats: got status %d", got)
+	}
+	if stats.Registrations != 2 || stats.Available != 1 || !stats.Relay.Enabled {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	var list []registration
+	if got := adminRequest(t, "GET", server.URL+"/api/peers", "s3cret", &list); got != http.StatusOK {
+		t.Fatalf("listing: got status %d", got)
+	}
+	if len(list) != 2 {
+		t.Fatalf("listed %d registrations, expected 2", len(list))
+	}
+	for _, reg := range list {
+		if reg.Code != "7" && reg.Code != "8" {
+			t.Errorf("unexpected code %q", reg.Code)
+		}
+		if reg.LastSeen.IsZero() {
+			t.Errorf("registration %s has no last seen time", reg.Code)
+		}
+	}
+
+	if got := adminRequest(t, "DELETE", server.URL+"/api/peers/9", "s3cret", nil); got != http.StatusNotFound {
+		t.Errorf("revoking an unknown code: got status %d", got)
+	}
+	if got := adminRequest(t, "DELETE", server.URL+"/api/peers/7", "s3cret", nil); got != http.StatusNoContent {
+		t.Fatalf("revoking: got status %d", got)
+	}
+	if err := peers.Refresh("7", token, 0); err != rendezvous.ErrRevoked {
+		t.Errorf("refreshing a revoked code: got %v", err)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	peers := newPeers()
+	rs := rendezvous.NewServer(peers)
+	rs.Hooks = metricsHooks()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go rs.Serve(listener)
+	t.Cleanup(func() { rs.Shutdown(context.Background()) })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := rendezvous.Hello(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(rendezvous.Request{Type: rendezvous.TypeAdd, Code: "7", Address: "10.0.0.1:9000"}); err != nil {
+		t.Fatal(err)
+	}
+	client.Do(rendezvous.Request{Type: rendezvous.TypeLook, Code: "7"})
+	client.Do(rendezvous.Request{Type: rendezvous.TypeLook, Code: "8"})
+	server := startAdmin(t, peers, "s3cret")
+
+	// Metrics are scraped without the admin token, like the health check.
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, series := range []string{
+		`directdrop_requests_total{type="LOOK"}`,
+		`directdrop_lookups_total{result="hit"}`,
+		`directdrop_lookups_total{result="miss"}`,
+		`directdrop_errors_total{code="404"}`,
+		"directdrop_registrations_total",
+	} {
+		if !strings.Contains(string(body), "\n"+series+" ") {
+			t.Errorf("metrics lack %s:\n%s", series, body)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+// Config holds the rendezvous server settings. Values are read from an
+// optional JSON config file and then overridden by command line flags.
+type Config struct {
+	Address         string   `json:"address"` // host to listen on, optionally with a port
+	Port            int      `json:"port"`
+	PeerTTL         Duration `json:"peerTTL"`         // registrations not seen for this long are dropped
+	GCInterval      Duration `json:"gcInterval"`      // how often stale registrations are collected
+	ShutdownTimeout Duration `json:"shutdownTimeout"` // how long clients may finish on shutdown
+	LogLevel        string   `json:"logLevel"`
+	TLSCert         string   `json:"tlsCert"`
+	TLSKey          string   `json:"tlsKey"`
+	TLSSelfSigned   bool     `json:"tlsSelfSigned"`
+	Relay           bool     `json:"relay"`
+	RelayRate       int64    `json:"relayRate"`
+	RelayTimeout    Duration `json:"relayTimeout"`
+	Store           string   `json:"store"` // file that keeps registrations across restarts, in memory if empty
+	Admin           string   `json:"admin"` // host:port of the HTTP admin API, disabled if empty
+	AdminToken      string   `json:"adminToken"`
+
+	// Abuse protection, 0 disables a limit.
+	MaxConns      int      `json:"maxConns"`      // open connections in total
+	MaxConnsPerIP int      `json:"maxConnsPerIP"` // open connections from one IP
+	MaxCodesPerIP int      `json:"maxCodesPerIP"` // registrations held by one IP
+	RateLimit     float64  `json:"rateLimit"`     // requests per second from one IP
+	ConnRateLimit float64  `json:"connRateLimit"` // requests per second on one connection
+	MissLimit     int      `json:"missLimit"`     // failed lookups from one IP before lookups back off
+	BanAfter      int      `json:"banAfter"`      // failed lookups from one IP before it is banned
+	BanDuration   Duration `json:"banDuration"`
+	MissWindow    Duration `json:"missWindow"` // failed lookups are forgotten after this long without one
+}
+
+// Duration is a time.Duration that reads and writes strings like "7m" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"7m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:            8080,
+		PeerTTL:         Duration{7 * time.Minute},
+		GCInterval:      Duration{time.Minute},
+		ShutdownTimeout: Duration{30 * time.Second},
+		LogLevel:        "info",
+		RelayTimeout:    Duration{30 * time.Minute},
+
+		MaxConns:      1000,
+		MaxConnsPerIP: 20,
+		MaxCodesPerIP: 50,
+		RateLimit:     20,
+		ConnRateLimit: 10,
+		MissLimit:     5,
+		BanAfter:      20,
+		BanDuration:   Duration{15 * time.Minute},
+		MissWindow:    Duration{10 * time.Minute},
+	}
+}
+
+// ParseConfig builds the server config from args. When -Config names a file
+// its settings replace the defaults and flags given on the command line take
+// precedence over both.
+func ParseConfig(args []string, output io.Writer) (*Config, error) {
+	cfg := defaultConfig()
+	fs, err := parseFlags(&cfg, args, output)
+	if err != nil {
+		return nil, err
+	}
+
+	if configFile := fs.Lookup("Config").Value.String(); configFile != "" {
+		cfg = defaultConfig()
+		if err := cfg.load(configFile); err != nil {
+			return nil, err
+		}
+		// Parse again so flags override the file.
+		if fs, err = parseFlags(&cfg, args, io.Discard); err != nil {
+			return nil, err
+		}
+	}
+
+	// -Address may carry a port as documented ("-Address <IP>:<Port>"), which
+	// applies unless -Port was given explicitly.
+	portSet := false
+	fs.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "Port" })
+	if host, port, err := net.SplitHostPort(cfg.Address); err == nil {
+		cfg.Address = host
+		if !portSet {
+			if cfg.Port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("invalid port in address %q", host+":"+port)
+			}
+		}
+	}
+
+	return &cfg, cfg.validate()
+}
+
+func parseFlags(cfg *Config, args []string, output io.Writer) (*flag.FlagSet, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	fs.String("Config", "", "Path to a JSON config file")
+	fs.StringVar(&cfg.Address, "Address", cfg.Address, "Address to listen on, either <IP> or <IP>:<Port>")
+	fs.IntVar(&cfg.Port, "Port", cfg.Port, "Port to listen on")
+	fs.DurationVar(&cfg.PeerTTL.Duration, "TTL", cfg.PeerTTL.Duration, "Drop registrations not seen for this long")
+	fs.DurationVar(&cfg.GCInterval.Duration, "GCInterval", cfg.GCInterval.Duration, "How often stale registrations are collected")
+	fs.DurationVar(&cfg.ShutdownTimeout.Duration, "ShutdownTimeout", cfg.ShutdownTimeout.Duration, "How long clients and relayed sessions may finish on shutdown")
+	fs.StringVar(&cfg.LogLevel, "LogLevel", cfg.LogLevel, "Log level: debug, info, warn or error")
+	fs.StringVar(&cfg.TLSCert, "TLSCert", cfg.TLSCert, "TLS certificate file")
+	fs.StringVar(&cfg.TLSKey, "TLSKey", cfg.TLSKey, "TLS private key file")
+	fs.BoolVar(&cfg.TLSSelfSigned, "TLSSelfSigned", cfg.TLSSelfSigned, "Serve TLS with a self-signed certificate, saved to -TLSCert/-TLSKey when given")
+	fs.BoolVar(&cfg.Relay, "Relay", cfg.Relay, "Relay traffic for peers that cannot connect directly")
+	fs.Int64Var(&cfg.RelayRate, "RelayRate", cfg.RelayRate, "Relay bandwidth limit in bytes per second per direction (0 for unlimited)")
+	fs.DurationVar(&cfg.RelayTimeout.Duration, "RelayTimeout", cfg.RelayTimeout.Duration, "Maximum duration of a relayed session")
+	fs.StringVar(&cfg.Store, "Store", cfg.Store, "File to keep registrations in across restarts (in memory if empty)")
+	fs.StringVar(&cfg.Admin, "Admin", cfg.Admin, "Serve the HTTP admin API on <IP>:<Port> (disabled if empty)")
+	fs.StringVar(&cfg.AdminToken, "AdminToken", cfg.AdminToken, "Bearer token required by the admin API")
+	fs.IntVar(&cfg.MaxConns, "MaxConns", cfg.MaxConns, "Maximum open connections (0 for unlimited)")
+	fs.IntVar(&cfg.MaxConnsPerIP, "MaxConnsPerIP", cfg.MaxConnsPerIP, "Maximum open connections from one IP (0 for unlimited)")
+	fs.IntVar(&cfg.MaxCodesPerIP, "MaxCodesPerIP", cfg.MaxCodesPerIP, "Maximum codes registered from one IP at a time (0 for unlimited)")
+	fs.Float64Var(&cfg.RateLimit, "RateLimit", cfg.RateLimit, "Requests per second allowed from one IP (0 for unlimited)")
+	fs.Float64Var(&cfg.ConnRateLimit, "ConnRateLimit", cfg.ConnRateLimit, "Requests per second allowed on one connection (0 for unlimited)")
+	fs.IntVar(&cfg.MissLimit, "MissLimit", cfg.MissLimit, "Failed lookups from one IP before its lookups back off (0 to never back off)")
+	fs.IntVar(&cfg.BanAfter, "BanAfter", cfg.BanAfter, "Failed lookups from one IP before it is banned (0 to never ban)")
+	fs.DurationVar(&cfg.BanDuration.Duration, "BanDuration", cfg.BanDuration.Duration, "How long a ban lasts")
+	fs.DurationVar(&cfg.MissWindow.Duration, "MissWindow", cfg.MissWindow.Duration, "Forget failed lookups from an IP after this long without one")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return fs, nil
+}
+
+func (c *Config) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535, got %d", c.Port)
+	}
+	if c.PeerTTL.Duration <= 0 {
+		return errors.New("TTL must be positive")
+	}
+	if c.GCInterval.Duration <= 0 {
+		return errors.New("GC interval must be positive")
+	}
+	if c.ShutdownTimeout.Duration < 0 {
+		return errors.New("shutdown timeout cannot be negative")
+	}
+	if _, err := c.Level(); err != nil {
+		return err
+	}
+	if c.TLSCert != "" && c.TLSKey == "" && !c.TLSSelfSigned {
+		return errors.New("-TLSKey is required with -TLSCert")
+	}
+	if c.RelayRate < 0 {
+		return errors.New("relay rate cannot be negative")
+	}
+	if c.MaxConns < 0 || c.MaxConnsPerIP < 0 || c.MaxCodesPerIP < 0 || c.RateLimit < 0 || c.ConnRateLimit < 0 || c.MissLimit < 0 || c.BanAfter < 0 {
+		return errors.New("limits cannot be negative")
+	}
+	if c.BanAfter > 0 && c.BanDuration.Duration <= 0 {
+		return errors.New("ban duration must be positive")
+	}
+	if c.Admin != "" {
+		if _, _, err := net.SplitHostPort(c.Admin); err != nil {
+			return fmt.Errorf("invalid admin address %q: use <IP>:<Port>", c.Admin)
+		}
+	}
+	return nil
+}
+
+// Limits returns the abuse protection settings.
+func (c *Config) Limits() rendezvous.Limits {
+	return rendezvous.Limits{
+		MaxConns:      c.MaxConns,
+		MaxConnsPerIP: c.MaxConnsPerIP,
+		MaxCodesPerIP: c.MaxCodesPerIP,
+		Rate:          c.RateLimit,
+		ConnRate:      c.ConnRateLimit,
+		MissLimit:     c.MissLimit,
+		BanAfter:      c.BanAfter,
+		BanDuration:   c.BanDuration.Duration,
+		MissWindow:    c.MissWindow.Duration,
+	}
+}
+
+// ListenAddr returns the host:port the server listens on.
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(c.Port))
+}
+
+// Level returns the slog level named by LogLevel.
+func (c *Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: use debug, info, warn or error", c.LogLevel)
+	}
+	return level, nil
+}
+
+// TLSEnabled reports whether the listener should serve TLS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCert != "" || c.TLSSelfSigned
+}
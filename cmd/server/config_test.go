@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(file, []byte(`{"port": 9000, "peerTTL": "10m", "logLevel": "debug"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		listenAddr string
+		ttl        time.Duration
+		logLevel   string
+		wantErr    bool
+	}{
+		{
+			name:       "defaults",
+			args:       nil,
+			listenAddr: ":8080",
+			ttl:        7 * time.Minute,
+			logLevel:   "info",
+		},
+		{
+			name:       "address with port",
+			args:       []string{"-Address", "127.0.0.1:9100"},
+			listenAddr: "127.0.0.1:9100",
+			ttl:        7 * time.Minute,
+			logLevel:   "info",
+		},
+		{
+			name:       "explicit port wins over address port",
+			args:       []string{"-Address", "127.0.0.1:9100", "-Port", "9200"},
+			listenAddr: "127.0.0.1:9200",
+			ttl:        7 * time.Minute,
+			logLevel:   "info",
+		},
+		{
+			name:       "config file",
+			args:       []string{"-Config", file},
+			listenAddr: ":9000",
+			ttl:        10 * time.Minute,
+			logLevel:   "debug",
+		},
+		{
+			name:       "flags override config file",
+			args:       []string{"-Config", file, "-TTL", "1m", "-LogLevel", "warn"},
+			listenAddr: ":9000",
+			ttl:        time.Minute,
+			logLevel:   "warn",
+		},
+		{
+			name:    "invalid log level",
+			args:    []string{"-LogLevel", "loud"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid port",
+			args:    []string{"-Port", "70000"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig(tt.args, io.Discard)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := cfg.ListenAddr(); got != tt.listenAddr {
+				t.Errorf("ListenAddr() = %q, want %q", got, tt.listenAddr)
+			}
+			if cfg.PeerTTL.Duration != tt.ttl {
+				t.Errorf("PeerTTL = %s, want %s", cfg.PeerTTL.Duration, tt.ttl)
+			}
+			if cfg.LogLevel != tt.logLevel {
+				t.Errorf("LogLevel = %q, want %q", cfg.LogLevel, tt.logLevel)
+			}
+		})
+	}
+}
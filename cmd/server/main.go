@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/pkg"
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+func main() {
+	cfg, err := ParseConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	level, _ := cfg.Level()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr())
+
+	if err != nil {
+		fatal("Starting the server", err)
+	}
+
+	if cfg.TLSEnabled() {
+		tlsConfig, err := pkg.ServerTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSSelfSigned)
+		if err != nil {
+			fatal("Configuring TLS", err)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+		slog.Info("TLS enabled", "fingerprint", pkg.CertFingerprint(tlsConfig.Certificates[0].Certificate[0]))
+	}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	slog.Info("TCP server started", "listen", listener.Addr().String(), "address", pkg.GetDeviceIPWithPort(port))
+
+	var store rendezvous.Store = rendezvous.NewMemoryStore()
+	if cfg.Store != "" {
+		fileStore, err := rendezvous.OpenFileStore(cfg.Store)
+		if err != nil {
+			fatal("Opening the store", err)
+		}
+		store = fileStore
+		slog.Info("Loaded registrations", "store", cfg.Store, "peers", fileStore.Len())
+	}
+
+	server := rendezvous.NewServer(rendezvous.NewPeers(store))
+	server.PeerTTL = cfg.PeerTTL.Duration
+	server.GCInterval = cfg.GCInterval.Duration
+	server.Limiter = rendezvous.NewLimiter(cfg.Limits())
+	server.Hooks = metricsHooks()
+	if cfg.Relay {
+		server.Relay = rendezvous.NewRelay(cfg.RelayRate, cfg.RelayTimeout.Duration, cfg.PeerTTL.Duration)
+		slog.Info("Relay enabled", "rate", cfg.RelayRate, "timeout", cfg.RelayTimeout.Duration)
+	}
+	registerGauges(server)
+
+	var adminServer *http.Server
+	if cfg.Admin != "" {
+		admin := NewAdmin(server)
+		admin.Token = cfg.AdminToken
+		adminServer = serveAdmin(cfg.Admin, admin)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(listener) }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		// Serve only returns early if the listener fails.
+		fatal("Serving clients", err)
+	case sig := <-signals:
+		slog.Info("Shutting down", "signal", sig.String(), "timeout", cfg.ShutdownTimeout.Duration)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if adminServer != nil {
+		adminServer.Shutdown(ctx)
+	}
+	if err != nil {
+		fatal("Shutting down", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// serveAdmin starts the admin API on addr and returns its HTTP server.
+func serveAdmin(addr string, admin *Admin) *http.Server {
+	if admin.Token == "" {
+		slog.Warn("Admin API has no token, anyone who can reach it can revoke codes", "listen", addr)
+	}
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           admin.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		slog.Info("Admin API started", "listen", addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			fatal("Serving the admin API", err)
+		}
+	}()
+	return server
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
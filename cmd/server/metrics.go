@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/internal/metrics"
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+// registry holds the server metrics served on /metrics of the admin API.
+var registry = metrics.NewRegistry()
+
+var (
+	requestsTotal      = registry.CounterVec("directdrop_requests_total", "Rendezvous requests received, by type.", "type")
+	errorsTotal        = registry.CounterVec("directdrop_errors_total", "ERROR responses sent, by error code.", "code")
+	lookupsTotal       = registry.CounterVec("directdrop_lookups_total", "LOOK requests, by result (hit or miss).", "result")
+	registrationsTotal = registry.Counter("directdrop_registrations_total", "New registrations accepted.")
+	gcEvictionsTotal   = registry.Counter("directdrop_gc_evictions_total", "Registrations removed because they went stale or expired.")
+	bansTotal          = registry.Counter("directdrop_bans_total", "Client IPs banned after too many failed lookups.")
+	relaySessionsTotal = registry.Counter("directdrop_relay_sessions_total", "Relayed sessions finished.")
+	relayBytesTotal    = registry.CounterVec("directdrop_relay_bytes_total", "Bytes relayed, by direction (up is receiver to sharer).", "direction")
+)
+
+// requestType returns the label for a request type, folding unknown types
+// together so clients cannot create arbitrary series.
+func requestType(typ string) string {
+	switch typ {
+	case rendezvous.TypeHello, rendezvous.TypeAdd, rendezvous.TypeLook, rendezvous.TypePing, rendezvous.TypeDel, rendezvous.TypeRelay:
+		return typ
+	}
+	return "other"
+}
+
+// metricsHooks returns server hooks that update the counters above.
+func metricsHooks() rendezvous.Hooks {
+	return rendezvous.Hooks{
+		OnRequest: func(typ string) { requestsTotal.With(requestType(typ)).Inc() },
+		OnError:   func(err *rendezvous.Error) { errorsTotal.With(strconv.Itoa(err.Code)).Inc() },
+		OnRegister: func(_ string, _ rendezvous.PeerInfo, updated bool) {
+			if !updated {
+				registrationsTotal.Inc()
+			}
+		},
+		OnLookup: func(_ string, found bool) {
+			if found {
+				lookupsTotal.With("hit").Inc()
+			} else {
+				lookupsTotal.With("miss").Inc()
+			}
+		},
+		OnCollect: func(string) { gcEvictionsTotal.Inc() },
+		OnBan:     func(string) { bansTotal.Inc() },
+		OnRelay: func(_ string, up, down int64) {
+			relaySessionsTotal.Inc()
+			relayBytesTotal.With("up").Add(float64(up))
+			relayBytesTotal.With("down").Add(float64(down))
+		},
+	}
+}
+
+// registerGauges adds the metrics read from the server state at scrape time.
+func registerGauges(server *rendezvous.Server) {
+	peers, relay := server.Peers(), server.Relay
+	registry.GaugeFunc("directdrop_registrations", "Registrations held, including used up, expired and revoked ones.", func() float64 {
+		n := 0
+		peers.Range(func(string, rendezvous.PeerInfo) bool { n++; return true })
+		return float64(n)
+	})
+	registry.GaugeFunc("directdrop_registrations_available", "Registrations receivers can look up.", func() float64 {
+		n, now := 0, time.Now()
+		peers.Range(func(_ string, peer rendezvous.PeerInfo) bool {
+			if peer.Available(now) {
+				n++
+			}
+			return true
+		})
+		return float64(n)
+	})
+	registry.GaugeFunc("directdrop_connections", "Open client connections.", func() float64 {
+		active, _ := server.Connections()
+		return float64(active)
+	})
+	registry.CounterFunc("directdrop_connections_total", "Client connections accepted.", func() float64 {
+		_, total := server.Connections()
+		return float64(total)
+	})
+	if relay != nil {
+		registry.GaugeFunc("directdrop_relay_standbys", "Sharers waiting on the relay for a receiver.", func() float64 {
+			return float64(relay.Standbys())
+		})
+		registry.GaugeFunc("directdrop_relay_sessions", "Relayed sessions in progress.", func() float64 {
+			return float64(relay.Sessions())
+		})
+	}
+}
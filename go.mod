@@ -1,3 +1,5 @@
 module github.com/sujalshah-bit/DirectDrop
 
 go 1.24.4
+
+require filippo.io/edwards25519 v1.2.0
@@ -1,20 +1,67 @@
 package config
 
+import (
+	"io/fs"
+	"time"
+)
+
+// Indexes of the flags returned by pkg.HandleFlags.
 const (
 	SERVER_ADDRESS = 0
 	CODE           = 1
 	ACTION         = 2
 	PATH           = 3
-	TIMEOUT        = 5
-	DIR            = "dir"
-	FILE           = "file"
+	TLS            = 4
+	FINGERPRINT    = 5
+	PORT           = 6
+	QR             = 7
+	JSON           = 8
+	WORDS          = 9
+	USES           = 10
+	EXPIRES        = 11
+	METRICS        = 12
+	YES            = 13
+	OUT            = 14
+	ON_CONFLICT    = 15
+	NO_PRESERVE    = 16
+)
+
+const (
+	TIMEOUT      = 5
+	RETRIES      = 5  // reconnect attempts after a transfer is interrupted
+	RETRY_DELAY  = 2  // seconds to wait between reconnect attempts
+	MAX_FAILURES = 3  // failed handshakes before a share closes itself
+	KEEPALIVE    = 60 // seconds between refreshes of a share's registration
+	DIR          = "dir"
+	FILE         = "file"
+	SYMLINK      = "symlink"
+	END          = "end" // closes a folder, so a cut connection is not taken for its end
+
+	MAX_MANIFEST_ENTRIES = 20 // top-level names listed in a Manifest
 )
 
 // Meta represents both file and directory metadata
 type Meta struct {
-	Type     string `json:"type"`               // "file" or "dir"
+	Type     string `json:"type"`               // "file", "dir", "symlink" or "end"
 	Path     string `json:"path,omitempty"`     // relative path for directories or files
 	Filename string `json:"filename,omitempty"` // optional filename for single file transfers
-	Size     int    `json:"size,omitempty"`     // size of compressed content (only for files)
-	Checksum string `json:"checksum,omitempty"` // checksum of compressed content (only for files)
+	Size     int64  `json:"size,omitempty"`     // size of the uncompressed content (only for files)
+	Checksum string `json:"checksum,omitempty"` // SHA256 of the uncompressed content (only for files)
+
+	Mode    fs.FileMode `json:"mode,omitempty"` // permission bits of files and directories
+	ModTime time.Time   `json:"mtime,omitzero"` // modification time of files and directories
+	Link    string      `json:"link,omitempty"` // slash separated target, relative to the link (only for symlinks)
+
+	Manifest *Manifest `json:"manifest,omitempty"` // summary of the whole share (only for the root)
+}
+
+// Manifest summarises a share so the receiver can decide whether to accept it
+// before anything is written.
+type Manifest struct {
+	Name    string   `json:"name"`              // base name of the shared file or folder
+	Files   int      `json:"files"`             // number of files
+	Dirs    int      `json:"dirs,omitempty"`    // number of directories below the root
+	Size    int64    `json:"size"`              // total size of all files
+	Entries []string `json:"entries,omitempty"` // top-level names, at most MAX_MANIFEST_ENTRIES
+	More    int      `json:"more,omitempty"`    // top-level names left out of Entries
 }
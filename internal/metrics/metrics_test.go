@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryOutput(t *testing.T) {
+	r := NewRegistry()
+	requests := r.CounterVec("requests_total", "Requests by type.", "type")
+	requests.With("LOOK").Add(2)
+	requests.With("ADD").Inc()
+	r.Gauge("active", "Active things.").Set(3)
+	r.GaugeFunc("answer", "The answer.", func() float64 { return 42 })
+	h := r.Histogram("duration_seconds", "Durations.", []float64{1, 10})
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(20)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP active Active things.
+# TYPE active gauge
+active 3
+# HELP answer The answer.
+# TYPE answer gauge
+answer 42
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="10"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 21.5
+duration_seconds_count 3
+# HELP requests_total Requests by type.
+# TYPE requests_total counter
+requests_total{type="ADD"} 1
+requests_total{type="LOOK"} 2
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("total", "Total.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a metric twice did not panic")
+		}
+	}()
+	r.Counter("total", "Total again.")
+}
@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sujalshah-bit/DirectDrop/internal/config"
+	"github.com/sujalshah-bit/DirectDrop/pkg"
+)
+
+// Conflict policies decide what happens to a received file whose name is
+// already taken in the target directory.
+const (
+	ConflictOverwrite = "overwrite" // replace the existing file
+	ConflictSkip      = "skip"      // keep the existing file and skip the received one
+	ConflictRename    = "rename"    // store the received file as "name (1).ext"
+	ConflictAsk       = "ask"       // let TCPClient.AskConflict decide per file
+)
+
+// ConflictPolicies lists the valid values of TCPClient.OnConflict.
+var ConflictPolicies = []string{ConflictOverwrite, ConflictSkip, ConflictRename, ConflictAsk}
+
+// maxRenames bounds the suffixes tried by ConflictRename.
+const maxRenames = 1000
+
+// resolveConflict returns the path the file described by meta is written to,
+// or "" if it should be skipped. A file that already holds the same content is
+// always skipped, so reconnecting after an interruption does not receive
+// finished files again.
+func (c *TCPClient) resolveConflict(path string, meta config.Meta) (string, error) {
+	exists, same, err := compareExisting(path, meta)
+	if err != nil || !exists {
+		return path, err
+	}
+	if same {
+		log.Printf("Skipping %s, it already has this content", path)
+		return "", nil
+	}
+
+	policy := c.OnConflict
+	if policy == ConflictAsk {
+		policy = ConflictSkip
+		if c.AskConflict != nil {
+			policy = c.AskConflict(path)
+		}
+	}
+	switch policy {
+	case "", ConflictOverwrite:
+		log.Printf("Overwriting %s", path)
+		return path, nil
+	case ConflictSkip:
+		log.Printf("Skipping %s, it already exists", path)
+		return "", nil
+	case ConflictRename:
+		return renameFree(path, meta)
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q", policy)
+	}
+}
+
+// renameFree returns the first "name (n).ext" next to path that is not taken,
+// or "" if one of them already holds the content described by meta.
+func renameFree(path string, meta config.Meta) (string, error) {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if stem == "" {
+		stem, ext = name, "" // dotfiles such as .bashrc have no extension
+	}
+
+	for n := 1; n <= maxRenames; n++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		exists, same, err := compareExisting(candidate, meta)
+		if err != nil {
+			return "", err
+		}
+		if same {
+			log.Printf("Skipping %s, %s already has this content", path, candidate)
+			return "", nil
+		}
+		if !exists {
+			log.Printf("%s exists, saving as %s", path, candidate)
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s after %d attempts", path, maxRenames)
+}
+
+// compareExisting reports whether something exists at path and whether it is
+// a regular file with the size and checksum announced in meta.
+func compareExisting(path string, meta config.Meta) (exists, same bool, err error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to check %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() || info.Size() != meta.Size {
+		return true, false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return true, false, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	checksum, err := pkg.CalculateChecksum(f)
+	if err != nil {
+		return true, false, fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+	return true, checksum == meta.Checksum, nil
+}
@@ -0,0 +1,87 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sujalshah-bit/DirectDrop/internal/config"
+)
+
+func TestConflictPolicies(t *testing.T) {
+	src := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "new", "same.txt": "same", ".env": "new"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, addr := startSharer(t, src)
+
+	for _, test := range []struct {
+		policy string
+		ask    string
+		want   map[string]string
+	}{
+		{ConflictOverwrite, "", map[string]string{"a.txt": "new", ".env": "new"}},
+		{ConflictSkip, "", map[string]string{"a.txt": "old", ".env": "old"}},
+		{ConflictRename, "", map[string]string{"a.txt": "old", "a (1).txt": "new", ".env": "old", ".env (1)": "new"}},
+		{ConflictAsk, ConflictRename, map[string]string{"a.txt": "old", "a (1).txt": "new", ".env": "old", ".env (1)": "new"}},
+		{ConflictAsk, "", map[string]string{"a.txt": "old", ".env": "old"}},
+	} {
+		t.Run(test.policy+"/"+test.ask, func(t *testing.T) {
+			client := newTestClient()
+			client.TargetDir = t.TempDir()
+			client.OnConflict = test.policy
+			if test.ask != "" {
+				client.AskConflict = func(string) string { return test.ask }
+			}
+			for name, content := range map[string]string{"a.txt": "old", "same.txt": "same", ".env": "old"} {
+				if err := os.WriteFile(filepath.Join(client.TargetDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Holds the same content as the share, so it is kept whatever
+			// the policy and never renamed.
+			test.want["same.txt"] = "same"
+
+			if err := client.RequestData(addr); err != nil {
+				t.Fatalf("RequestData failed: %v", err)
+			}
+			if entries, _ := os.ReadDir(client.TargetDir); len(entries) != len(test.want) {
+				t.Errorf("target holds %d entries, expected %d", len(entries), len(test.want))
+			}
+			for name, content := range test.want {
+				got, err := os.ReadFile(filepath.Join(client.TargetDir, name))
+				if err != nil || string(got) != content {
+					t.Errorf("%s = %q, %v; expected %q", name, got, err, content)
+				}
+			}
+		})
+	}
+}
+
+// checksumMeta describes a file holding content.
+func checksumMeta(content string) config.Meta {
+	sum := sha256.Sum256([]byte(content))
+	return config.Meta{Type: config.FILE, Size: int64(len(content)), Checksum: hex.EncodeToString(sum[:])}
+}
+
+func TestRenameFreeReusesIdenticalCopy(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "old", "a (1).txt": "new"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &TCPClient{OnConflict: ConflictRename}
+	meta := checksumMeta("new")
+	if got, err := client.resolveConflict(filepath.Join(dir, "a.txt"), meta); err != nil || got != "" {
+		t.Errorf("resolveConflict = %q, %v; expected the identical copy to be skipped", got, err)
+	}
+	meta = checksumMeta("newer")
+	if got, err := client.resolveConflict(filepath.Join(dir, "a.txt"), meta); err != nil || got != filepath.Join(dir, "a (2).txt") {
+		t.Errorf("resolveConflict = %q, %v; expected a (2).txt", got, err)
+	}
+}
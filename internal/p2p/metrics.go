@@ -0,0 +1,16 @@
+package p2p
+
+import "github.com/sujalshah-bit/DirectDrop/internal/metrics"
+
+// Metrics holds the transfer metrics of this process, served with -Metrics
+// by both a share and a receive.
+var Metrics = metrics.NewRegistry()
+
+var (
+	bytesSentTotal         = Metrics.Counter("directdrop_bytes_sent_total", "File bytes sent to receivers.")
+	bytesReceivedTotal     = Metrics.Counter("directdrop_bytes_received_total", "File bytes received from sharers.")
+	transfersTotal         = Metrics.CounterVec("directdrop_transfers_total", "Transfers served to receivers, by result (ok or failed).", "result")
+	transferSeconds        = Metrics.Histogram("directdrop_transfer_duration_seconds", "Duration of completed transfers.", metrics.DurationBuckets)
+	checksumFailuresTotal  = Metrics.Counter("directdrop_checksum_failures_total", "Received files discarded because their size or checksum was wrong.")
+	handshakeFailuresTotal = Metrics.Counter("directdrop_handshake_failures_total", "Receivers that failed the handshake, most often with a wrong code.")
+)
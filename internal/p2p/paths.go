@@ -0,0 +1,213 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned when a sharer announces a path that would be
+// written outside the target directory or cannot be stored portably.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// reservedChars cannot appear in file names on Windows, so a share relying on
+// them could not be received there and is refused everywhere.
+const reservedChars = `<>:"|?*`
+
+// reservedNames are device names on Windows, with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// resolvePath returns where name, a path announced by the sharer, is stored
+// under root. Both slashes and backslashes separate elements, so paths from
+// Windows sharers work too. The path must be relative and stay within root,
+// also once symlinks already on disk are followed, and must not name root
+// itself.
+func resolvePath(root, name string) (string, error) {
+	return resolve(root, name, false)
+}
+
+// resolveDir is resolvePath for a directory entry, which may also name root
+// itself as "." like the first entry of a folder does.
+func resolveDir(root, name string) (string, error) {
+	return resolve(root, name, true)
+}
+
+func resolve(root, name string, allowRoot bool) (string, error) {
+	elems, err := pathElems(name)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrUnsafePath, name, err)
+	}
+	if len(elems) == 0 && !allowRoot {
+		return "", fmt.Errorf("%w %q: names the target directory itself", ErrUnsafePath, name)
+	}
+	path := filepath.Join(append([]string{root}, elems...)...)
+	if err := checkSymlinks(root, elems); err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrUnsafePath, name, err)
+	}
+	return path, nil
+}
+
+// pathElems splits name into its elements, rejecting anything that is not a
+// plain relative path.
+func pathElems(name string) ([]string, error) {
+	if name == "" {
+		return nil, errors.New("empty path")
+	}
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return nil, errors.New("absolute path")
+	}
+
+	var elems []string
+	for _, elem := range strings.Split(slashed, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			return nil, errors.New("parent directory reference")
+		}
+		if err := checkElem(elem); err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// checkElem rejects a single path element that Windows could not store or
+// would treat as a device.
+func checkElem(elem string) error {
+	for _, r := range elem {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("control character in %q", elem)
+		}
+		if strings.ContainsRune(reservedChars, r) {
+			return fmt.Errorf("reserved character %q in %q", r, elem)
+		}
+	}
+	if strings.HasSuffix(elem, ".") || strings.HasSuffix(elem, " ") {
+		return fmt.Errorf("trailing dot or space in %q", elem)
+	}
+	base, _, _ := strings.Cut(elem, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return fmt.Errorf("reserved device name %q", elem)
+	}
+	return nil
+}
+
+// checkSymlinks follows the elements of a path below root as far as they
+// exist and fails if a symlink among them leads outside root.
+func checkSymlinks(root string, elems []string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // nothing below root exists yet
+	}
+	if err != nil {
+		return err
+	}
+
+	path := root
+	for _, elem := range elems {
+		path = filepath.Join(path, elem)
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("dangling symlink %s", path)
+		}
+		if err != nil {
+			return err
+		}
+		if !within(realRoot, target) {
+			return fmt.Errorf("symlink %s leads outside the target directory", path)
+		}
+	}
+	return nil
+}
+
+// checkLink fails if a symlink created at path, a path below root, with the
+// relative target link would lead outside root. The link is resolved from the
+// real location of its directory, following the symlinks already on disk, so
+// earlier links of a share cannot be chained to escape. link must be cleaned,
+// leaving any ".." elements at its start where they apply to directories that
+// exist and hold the link.
+func checkLink(root, path, link string) error {
+	realRoot, err := realPath(root)
+	if err != nil {
+		return err
+	}
+	dir, err := realPath(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(dir, link)
+	if !within(realRoot, target) {
+		return errors.New("symlink leads outside the target directory")
+	}
+	rel, err := filepath.Rel(realRoot, target)
+	if err != nil || rel == "." {
+		return err
+	}
+	return checkSymlinks(realRoot, strings.Split(rel, string(filepath.Separator)))
+}
+
+// realPath resolves the symlinks in the part of path that exists and appends
+// the rest.
+func realPath(path string) (string, error) {
+	real, err := filepath.EvalSymlinks(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return real, err
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	real, err = realPath(parent)
+	return filepath.Join(real, filepath.Base(path)), err
+}
+
+// sidecarPath returns the file next to outputPath, a path below root, whose
+// name adds suffix. The sharer controls the names in a share, so like them it
+// must resolve within root, and it must be a regular file if it exists, so
+// writing it cannot follow a symlink.
+func sidecarPath(root, outputPath, suffix string) (string, error) {
+	rel, err := filepath.Rel(root, outputPath)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrUnsafePath, outputPath, err)
+	}
+	path, err := resolvePath(root, rel+suffix)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%w %q: not a regular file", ErrUnsafePath, path)
+	}
+	return path, nil
+}
+
+// within reports whether path is root or lies below it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
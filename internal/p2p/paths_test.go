@@ -0,0 +1,206 @@
+package p2p
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sujalshah-bit/DirectDrop/internal/config"
+	"github.com/sujalshah-bit/DirectDrop/pkg"
+)
+
+func TestResolvePath(t *testing.T) {
+	root := t.TempDir()
+	for name, want := range map[string]string{
+		"a.txt":         filepath.Join(root, "a.txt"),
+		"nested/b.txt":  filepath.Join(root, "nested", "b.txt"),
+		`nested\c.txt`:  filepath.Join(root, "nested", "c.txt"),
+		"./d/./e.txt":   filepath.Join(root, "d", "e.txt"),
+		"report.v2.pdf": filepath.Join(root, "report.v2.pdf"),
+	} {
+		got, err := resolvePath(root, name)
+		if err != nil || got != want {
+			t.Errorf("resolvePath(%q) = %q, %v; expected %q", name, got, err, want)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		".",
+		"./.",
+		"../evil",
+		"a/../../evil",
+		`..\evil`,
+		"/etc/passwd",
+		`\evil`,
+		`C:\evil`,
+		"C:evil",
+		"nul",
+		"CON.txt",
+		"dir/LPT1",
+		"a:b",
+		"what?",
+		"tab\tname",
+		"trailing.",
+		"trailing ",
+	} {
+		if got, err := resolvePath(root, name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("resolvePath(%q) = %q, %v; expected ErrUnsafePath", name, got, err)
+		}
+	}
+
+	// Only a directory entry may name the target directory itself.
+	for _, name := range []string{".", "./", "./."} {
+		if got, err := resolveDir(root, name); err != nil || got != root {
+			t.Errorf("resolveDir(%q) = %q, %v; expected %q", name, got, err, root)
+		}
+	}
+}
+
+func TestResolvePathSymlinks(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "in")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"out", "out/evil", "dangling"} {
+		if _, err := resolvePath(root, name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("resolvePath(%q): got %v, expected ErrUnsafePath", name, err)
+		}
+	}
+	if _, err := resolvePath(root, "in/a.txt"); err != nil {
+		t.Errorf("symlink within the target directory: %v", err)
+	}
+}
+
+func TestReceiveRefusesUnsafePath(t *testing.T) {
+	parent := t.TempDir()
+	client := newTestClient()
+	client.TargetDir = filepath.Join(parent, "target")
+
+	receiver, sharer := net.Pipe()
+	defer sharer.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.receiveObject(receiver)
+		receiver.Close()
+	}()
+
+	if err := pkg.SendMetadata(sharer, map[string]string{"type": "dir"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.WaitAck(sharer); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.SendMetadata(sharer, map[string]any{"type": "file", "path": "../evil.txt", "size": 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkg.ReadAck(sharer); !errors.Is(err, pkg.ErrRefused) {
+		t.Errorf("sharer got %v, expected a refusal", err)
+	}
+	if err := <-done; !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("receiver returned %v, expected ErrUnsafePath", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "evil.txt")); !os.IsNotExist(err) {
+		t.Errorf("file was written outside the target directory: %v", err)
+	}
+}
+
+func TestReceiveRefusesEscapingSymlink(t *testing.T) {
+	client := newTestClient()
+	client.TargetDir = t.TempDir()
+
+	receiver, sharer := net.Pipe()
+	defer sharer.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.receiveFolder(receiver, bufio.NewReader(receiver))
+		receiver.Close()
+	}()
+
+	meta := config.Meta{Type: config.SYMLINK, Path: "sub/link", Link: "../../outside"}
+	if err := pkg.SendMetadata(sharer, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.WaitAck(sharer); !errors.Is(err, pkg.ErrRefused) {
+		t.Errorf("sharer got %v, expected a refusal", err)
+	}
+	if err := <-done; !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("receiver returned %v, expected ErrUnsafePath", err)
+	}
+	if _, err := os.Lstat(filepath.Join(client.TargetDir, "sub", "link")); !os.IsNotExist(err) {
+		t.Errorf("escaping symlink was created: %v", err)
+	}
+}
+
+func TestReceiveRefusesChainedSymlinks(t *testing.T) {
+	client := newTestClient()
+	client.TargetDir = filepath.Join(t.TempDir(), "target")
+
+	receiver, sharer := net.Pipe()
+	defer sharer.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.receiveFolder(receiver, bufio.NewReader(receiver))
+		receiver.Close()
+	}()
+
+	// Each link stays inside lexically, but the second one is created in the
+	// target directory itself by following the first.
+	first := config.Meta{Type: config.SYMLINK, Path: "a/b/c/s", Link: "../../.."}
+	if err := pkg.SendMetadata(sharer, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.WaitAck(sharer); err != nil {
+		t.Fatalf("link back to the target directory refused: %v", err)
+	}
+	second := config.Meta{Type: config.SYMLINK, Path: "a/b/c/s/t", Link: "../../.."}
+	if err := pkg.SendMetadata(sharer, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.WaitAck(sharer); !errors.Is(err, pkg.ErrRefused) {
+		t.Errorf("sharer got %v, expected a refusal", err)
+	}
+	if err := <-done; !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("receiver returned %v, expected ErrUnsafePath", err)
+	}
+	if _, err := os.Lstat(filepath.Join(client.TargetDir, "t")); !os.IsNotExist(err) {
+		t.Errorf("chained symlink was created: %v", err)
+	}
+}
+
+func TestWriteFileRefusesLinkedPart(t *testing.T) {
+	for _, suffix := range []string{partSuffix, manifestSuffix} {
+		t.Run(suffix, func(t *testing.T) {
+			// Even a link that stays within the target directory is not
+			// followed.
+			dir := t.TempDir()
+			victim, output := filepath.Join(dir, "victim"), filepath.Join(dir, "evil")
+			if err := os.WriteFile(victim, []byte("keep"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("victim", output+suffix); err != nil {
+				t.Skipf("cannot create symlinks: %v", err)
+			}
+			err := sendToReceiver(t, output, checksumMeta("evil"), []byte("evil"), false)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("writeFile returned %v, expected ErrUnsafePath", err)
+			}
+			if got, _ := os.ReadFile(victim); string(got) != "keep" {
+				t.Errorf("file behind the symlink was overwritten with %q", got)
+			}
+		})
+	}
+}
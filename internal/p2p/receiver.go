@@ -2,7 +2,11 @@ package p2p
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,17 +14,42 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/sujalshah-bit/DirectDrop/internal/config"
+	"github.com/sujalshah-bit/DirectDrop/internal/secure"
 	"github.com/sujalshah-bit/DirectDrop/pkg"
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
 )
 
 type TCPClient struct {
 	ServerAddr string
 	Timeout    time.Duration
 	TargetDir  string
+	TLSConfig  *tls.Config // when set, the rendezvous server is reached over TLS
 	conn       net.Conn
+	server     *rendezvous.Client
+	nameplate  string // public part of the code, used to find the sharer
+	secret     string // secret part of the code, never sent to the server
+	viaRelay   bool   // set once the sharer proved unreachable directly
+	accepted   bool   // set once Confirm accepted the share, so resuming does not ask again
+
+	// Confirm, when set, is shown the manifest of the share before anything
+	// is written and declines the transfer by returning false. The manifest
+	// is nil if the sharer did not send one.
+	Confirm func(manifest *config.Manifest) bool
+
+	// OnConflict is the policy for received files whose name is taken, one
+	// of ConflictPolicies; empty overwrites. With ConflictAsk, AskConflict is
+	// called with the path and returns one of the other policies; without it
+	// such files are skipped.
+	OnConflict  string
+	AskConflict func(path string) string
+
+	// NoPreserve leaves received files and directories with default modes
+	// and the time they were written instead of restoring the sharer's.
+	NoPreserve bool
 }
 
 // NewTCPClient creates a new TCP client instance
@@ -32,13 +61,25 @@ func NewTCPClient(serverAddr string, timeout time.Duration) *TCPClient {
 	}
 }
 
+// ErrCodeNotFound is returned by ReceiveCode when the server knows no share
+// for the code.
+var ErrCodeNotFound = errors.New("no share found for this code, it may have expired or been used up")
+
+// ErrDeclined is returned by RequestData when Confirm declined the share.
+var ErrDeclined = errors.New("transfer declined by the receiver")
+
+// ErrUnavailable is returned by RequestData when the sharer refused the
+// transfer, typically because its remaining downloads are taken.
+var ErrUnavailable = errors.New("sharer refused the transfer")
+
 // Connect establishes a connection to the server
 func (c *TCPClient) Connect() error {
-	conn, err := net.DialTimeout("tcp", c.ServerAddr, c.Timeout)
+	conn, server, err := rendezvous.Dial(c.ServerAddr, c.Timeout, c.TLSConfig)
 	if err != nil {
 		return err
 	}
 	c.conn = conn
+	c.server = server
 	return nil
 }
 
@@ -61,44 +102,125 @@ func (c *TCPClient) Reconnect() error {
 	return c.Connect()
 }
 
-// ReceiveCode sends a LOOK command and returns the server response
+// ReceiveCode looks up the nameplate of code on the server and returns the
+// sharer's address, or ErrCodeNotFound. The secret part of the code is kept
+// for the handshake with the sharer and never sent to the server.
 func (c *TCPClient) ReceiveCode(code string) (string, error) {
+	nameplate, secret, err := pkg.SplitCode(code)
+	if err != nil {
+		return "", err
+	}
+	c.nameplate = nameplate
+	c.secret = secret
+
 	if !c.IsConnected() {
 		if err := c.Connect(); err != nil {
 			return "", err
 		}
 	}
 
-	_, err := fmt.Fprintf(c.conn, "LOOK %s\n", code)
-	if err != nil {
-		c.conn = nil
-		return "", fmt.Errorf("failed to send lookup request: %w", err)
-	}
-
 	// Set read timeout
-	if err := c.conn.SetReadDeadline(time.Now().Add(c.Timeout)); err != nil {
-		return "", fmt.Errorf("failed to set read timeout: %w", err)
+	if err := c.conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return "", fmt.Errorf("failed to set timeout: %w", err)
 	}
 
-	// Read response from server
-	response, err := bufio.NewReader(c.conn).ReadString('\n')
+	resp, err := c.server.Do(rendezvous.Request{Type: rendezvous.TypeLook, Code: nameplate})
+	if errors.Is(err, rendezvous.ErrNotFound) {
+		return "", ErrCodeNotFound
+	}
 	if err != nil {
 		c.conn = nil
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return "", fmt.Errorf("lookup failed: %w", err)
 	}
-
-	return strings.TrimSpace(response), nil
+	return resp.Address, nil
 }
 
+// RequestData downloads the shared object from the sharer at IP. If the
+// connection drops midway it reconnects and resumes from the partial data
+// already on disk, giving up after config.RETRIES attempts.
 func (c *TCPClient) RequestData(IP string) error {
 	pkg.UnsafeModifyStr(&IP) // assuming you really need this
-	conn, err := net.DialTimeout("tcp", IP, c.Timeout)
+
+	var err error
+	for attempt := 0; attempt <= config.RETRIES; attempt++ {
+		if attempt > 0 {
+			log.Printf("Transfer interrupted (%v), reconnecting (attempt %d/%d)", err, attempt, config.RETRIES)
+			time.Sleep(config.RETRY_DELAY * time.Second)
+		}
+
+		err = c.requestOnce(IP)
+		if err == nil || !isConnError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *TCPClient) requestOnce(IP string) error {
+	conn, err := c.dialSharer(IP)
 	if err != nil {
-		return fmt.Errorf("failed to connect to server %s: %w", IP, err)
+		return err
 	}
 	defer conn.Close()
 
-	return c.receiveObject(conn)
+	if err := conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return err
+	}
+	secureConn, err := secure.Handshake(conn, c.secret, true)
+	if err != nil {
+		return fmt.Errorf("handshake with %s failed: %w", IP, err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	return c.receiveObject(secureConn)
+}
+
+// dialSharer connects to the sharer directly and falls back to the rendezvous
+// server's relay when that fails, typically because the sharer is behind NAT
+// or a firewall. Once the relay was needed it is used for later attempts too.
+func (c *TCPClient) dialSharer(IP string) (net.Conn, error) {
+	if !c.viaRelay {
+		conn, err := net.DialTimeout("tcp", IP, c.Timeout)
+		if err == nil {
+			return conn, nil
+		}
+		if c.nameplate == "" {
+			return nil, fmt.Errorf("failed to connect to server %s: %w", IP, err)
+		}
+		log.Printf("Direct connection to %s failed (%v), falling back to relay", IP, err)
+		c.viaRelay = true
+	}
+	return c.dialRelay()
+}
+
+// dialRelay asks the rendezvous server to splice a connection through to the
+// sharer's standby connection.
+func (c *TCPClient) dialRelay() (net.Conn, error) {
+	conn, server, err := rendezvous.Dial(c.ServerAddr, c.Timeout, c.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server may wait a moment for the sharer's standby connection.
+	if err := conn.SetDeadline(time.Now().Add(2 * c.Timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := server.Do(rendezvous.Request{Type: rendezvous.TypeRelay, Code: c.nameplate}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("relay refused: %w", err)
+	}
+	return conn, conn.SetDeadline(time.Time{})
+}
+
+// isConnError reports whether err was caused by the connection failing, as
+// opposed to bad data, in which case the transfer is worth retrying.
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
 }
 
 func (c *TCPClient) receiveObject(conn net.Conn) error {
@@ -108,12 +230,19 @@ func (c *TCPClient) receiveObject(conn net.Conn) error {
 	if err != nil {
 		return fmt.Errorf("failed to read metadata: %w", err)
 	}
+	if reason, ok := strings.CutPrefix(strings.TrimSpace(string(metaLine)), "ERROR "); ok {
+		return fmt.Errorf("%w: %s", ErrUnavailable, reason)
+	}
 
 	var meta config.Meta
 	if err := json.Unmarshal(metaLine, &meta); err != nil {
 		return fmt.Errorf("invalid metadata: %w", err)
 	}
 
+	if err := c.confirm(meta.Manifest); err != nil {
+		return refuse(conn, err)
+	}
+
 	// Send ack back
 	if _, err := conn.Write([]byte("OK\n")); err != nil {
 		return fmt.Errorf("failed to send ack: %w", err)
@@ -121,17 +250,27 @@ func (c *TCPClient) receiveObject(conn net.Conn) error {
 
 	switch meta.Type {
 	case config.DIR:
-		return c.receiveFolder(conn)
+		return c.receiveFolder(conn, reader)
 	case config.FILE:
-		return c.receiveFile(conn)
+		return c.receiveFile(conn, reader)
 	default:
 		return fmt.Errorf("unknown object type: %s", meta.Type)
 	}
 }
 
-func (c *TCPClient) receiveFile(conn net.Conn) error {
-	reader := bufio.NewReader(conn)
+// confirm asks Confirm whether to accept the share described by manifest.
+func (c *TCPClient) confirm(manifest *config.Manifest) error {
+	if c.Confirm == nil || c.accepted {
+		return nil
+	}
+	if !c.Confirm(manifest) {
+		return ErrDeclined
+	}
+	c.accepted = true
+	return nil
+}
 
+func (c *TCPClient) receiveFile(conn net.Conn, reader *bufio.Reader) error {
 	// read metadata
 	metaLine, err := reader.ReadBytes('\n')
 	if err != nil {
@@ -143,57 +282,38 @@ func (c *TCPClient) receiveFile(conn net.Conn) error {
 		return fmt.Errorf("invalid file metadata: %w", err)
 	}
 
-	outputDir := "./download"
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create download dir: %w", err)
-	}
-	outputPath := filepath.Join(outputDir, meta.Filename)
-
-	// create empty file
-	if f, err := os.Create(outputPath); err == nil {
-		f.Close()
-	} else {
-		return fmt.Errorf("failed to create file %s: %w", outputPath, err)
-	}
-
-	// send ack
-	if _, err := conn.Write([]byte("OK\n")); err != nil {
-		return fmt.Errorf("failed to send ack: %w", err)
-	}
-
-	// read compressed data
-	compressed := make([]byte, meta.Size)
-	if _, err := io.ReadFull(reader, compressed); err != nil {
-		return fmt.Errorf("failed to read file data: %w", err)
+	outputPath, err := resolvePath(c.TargetDir, meta.Filename)
+	if err != nil {
+		return refuse(conn, err)
 	}
-
-	// verify checksum
-	if err := pkg.VerifyChecksum(compressed, meta.Checksum); err != nil {
-		return fmt.Errorf("file checksum error for %s: %w", meta.Filename, err)
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create download dir: %w", err)
 	}
 
-	// decompress
-	data, err := pkg.DecompressData(compressed)
+	outputPath, err = c.receiveInto(conn, reader, outputPath, meta)
 	if err != nil {
-		return fmt.Errorf("failed to decompress file %s: %w", meta.Filename, err)
+		return err
 	}
-
-	// write to file
-	if err := os.WriteFile(outputPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write file %s: %w", outputPath, err)
+	if outputPath != "" {
+		log.Printf("File received: %s (%d bytes)", outputPath, meta.Size)
+	}
+	// Like the end of a folder, tell the sharer the file is in place.
+	if _, err := conn.Write([]byte("OK\n")); err != nil {
+		return fmt.Errorf("failed to confirm file: %w", err)
 	}
-
-	log.Printf("File received: %s (%d bytes)", outputPath, len(data))
 	return nil
 }
 
-func (c *TCPClient) receiveFolder(conn net.Conn) error {
-	reader := bufio.NewReader(conn)
-
+func (c *TCPClient) receiveFolder(conn net.Conn, reader *bufio.Reader) error {
+	// Directory attributes are restored once the folder is complete, since
+	// writing their contents changes their times and a read-only mode would
+	// keep them from being written at all.
+	var dirs []config.Meta
 	for {
 		metaLine, err := reader.ReadBytes('\n')
 		if err == io.EOF {
-			break // end of folder
+			// Only the sharer's end message completes a folder.
+			return fmt.Errorf("folder ended before the sharer finished it: %w", io.ErrUnexpectedEOF)
 		}
 		if err != nil {
 			return fmt.Errorf("failed to read folder metadata: %w", err)
@@ -203,8 +323,18 @@ func (c *TCPClient) receiveFolder(conn net.Conn) error {
 		if err := json.Unmarshal(metaLine, &meta); err != nil {
 			return fmt.Errorf("invalid folder metadata: %w", err)
 		}
+		if meta.Type == config.END {
+			break
+		}
 
-		fullPath := filepath.Join(c.TargetDir, meta.Path)
+		resolve := resolvePath
+		if meta.Type == config.DIR {
+			resolve = resolveDir
+		}
+		fullPath, err := resolve(c.TargetDir, meta.Path)
+		if err != nil {
+			return refuse(conn, err)
+		}
 
 		if meta.Type == "dir" {
 			// create directory
@@ -215,6 +345,15 @@ func (c *TCPClient) receiveFolder(conn net.Conn) error {
 				return fmt.Errorf("failed to ack dir %s: %w", fullPath, err)
 			}
 			log.Printf("Directory created: %s", fullPath)
+			meta.Path = fullPath
+			dirs = append(dirs, meta)
+			continue
+		}
+
+		if meta.Type == config.SYMLINK {
+			if err := c.receiveSymlink(conn, fullPath, meta); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -222,36 +361,304 @@ func (c *TCPClient) receiveFolder(conn net.Conn) error {
 		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
 			return fmt.Errorf("failed to create parent dirs for %s: %w", fullPath, err)
 		}
-		if f, err := os.Create(fullPath); err == nil {
-			f.Close()
-		} else {
-			return fmt.Errorf("failed to create file %s: %w", fullPath, err)
+		fullPath, err = c.receiveInto(conn, reader, fullPath, meta)
+		if err != nil {
+			return err
+		}
+		if fullPath == "" {
+			continue
 		}
 
-		if _, err := conn.Write([]byte("OK\n")); err != nil {
-			return fmt.Errorf("failed to ack file %s: %w", fullPath, err)
+		log.Printf("File written: %s (%d bytes)", fullPath, meta.Size)
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		c.restoreAttrs(dirs[i].Path, dirs[i])
+	}
+	if _, err := conn.Write([]byte("OK\n")); err != nil {
+		return fmt.Errorf("failed to ack end of folder: %w", err)
+	}
+	return nil
+}
+
+// receiveSymlink creates the symlink described by meta at path, refusing
+// links that lead outside the target directory.
+func (c *TCPClient) receiveSymlink(conn net.Conn, path string, meta config.Meta) error {
+	// The link is stored cleaned, so a ".." cannot later apply to a symlink
+	// in the middle of it.
+	link := filepath.Clean(filepath.FromSlash(meta.Link))
+	if meta.Link == "" || strings.HasPrefix(meta.Link, "/") || filepath.IsAbs(link) || filepath.VolumeName(link) != "" {
+		return refuse(conn, fmt.Errorf("%w %q: symlink to %q is not relative", ErrUnsafePath, meta.Path, meta.Link))
+	}
+	if err := checkLink(c.TargetDir, path, link); err != nil {
+		return refuse(conn, fmt.Errorf("%w %q: symlink to %q: %v", ErrUnsafePath, meta.Path, meta.Link, err))
+	}
+
+	// An identical link from an earlier transfer is kept as it is.
+	target := ""
+	if existing, _ := os.Readlink(path); existing != link {
+		var err error
+		if target, err = c.resolveConflict(path, meta); err != nil {
+			return err
+		}
+	}
+	if target != "" {
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
 		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create parent dirs for %s: %w", target, err)
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %w", target, err)
+		}
+		log.Printf("Symlink created: %s -> %s", target, link)
+	}
+
+	if _, err := conn.Write([]byte("OK\n")); err != nil {
+		return fmt.Errorf("failed to ack symlink %s: %w", path, err)
+	}
+	return nil
+}
 
-		compressed := make([]byte, meta.Size)
-		if _, err := io.ReadFull(reader, compressed); err != nil {
-			return fmt.Errorf("failed to read file %s: %w", meta.Path, err)
+// restoreAttrs gives path the mode and modification time the sharer sent in
+// meta, unless NoPreserve is set. Not every file system supports them, so
+// failures are only logged.
+func (c *TCPClient) restoreAttrs(path string, meta config.Meta) {
+	if c.NoPreserve {
+		return
+	}
+	if meta.Mode != 0 {
+		if err := os.Chmod(path, meta.Mode.Perm()); err != nil {
+			log.Printf("Failed to restore the mode of %s: %v", path, err)
+		}
+	}
+	if !meta.ModTime.IsZero() {
+		if err := os.Chtimes(path, time.Time{}, meta.ModTime); err != nil {
+			log.Printf("Failed to restore the modification time of %s: %v", path, err)
 		}
+	}
+}
 
-		if err := pkg.VerifyChecksum(compressed, meta.Checksum); err != nil {
-			return fmt.Errorf("checksum error for %s: %w", meta.Path, err)
+// receiveInto applies the conflict policy to path and receives the file
+// described by meta there. It returns the path written, or "" if the file was
+// skipped, in which case the sharer is told not to send it.
+func (c *TCPClient) receiveInto(conn net.Conn, reader *bufio.Reader, path string, meta config.Meta) (string, error) {
+	target, err := c.resolveConflict(path, meta)
+	if err != nil {
+		return "", err
+	}
+	if target == "" {
+		if _, err := conn.Write([]byte("SKIP\n")); err != nil {
+			return "", fmt.Errorf("failed to skip file %s: %w", path, err)
 		}
+		return "", nil
+	}
+	if err := c.writeFile(conn, reader, target, meta); err != nil {
+		return "", err
+	}
+	c.restoreAttrs(target, meta)
+	return target, nil
+}
 
-		data, err := pkg.DecompressData(compressed)
-		if err != nil {
-			return fmt.Errorf("decompression error for %s: %w", meta.Path, err)
+// refuse tells the sharer why the object it announced is not accepted and
+// returns err.
+func refuse(conn net.Conn, err error) error {
+	if sendErr := pkg.SendRefusal(conn, err.Error()); sendErr != nil {
+		log.Printf("Failed to tell the sharer about the refusal: %v", sendErr)
+	}
+	return err
+}
+
+const (
+	partSuffix     = ".part"
+	manifestSuffix = ".part.json"
+)
+
+// partManifest is stored next to a .part file and identifies the content
+// being received, so an interrupted transfer is only ever resumed against the
+// same version of the file.
+type partManifest struct {
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// writeFile acks the file described by meta and streams its chunks into a
+// .part file next to outputPath, so the received data never shows under the
+// final name until it is complete. The .part file is fsynced and its size and
+// whole-file checksum verified before it is renamed into place. A .part file
+// left by an interrupted transfer of the same content is resumed instead of
+// starting over; it is only kept when the connection dropped and it can be
+// resumed, any other failure removes it.
+func (c *TCPClient) writeFile(conn net.Conn, reader *bufio.Reader, outputPath string, meta config.Meta) (err error) {
+	f, offset, err := openPart(c.TargetDir, outputPath, meta)
+	if errors.Is(err, ErrUnsafePath) {
+		return refuse(conn, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		if err != nil && (!isConnError(err) || f.manifest == "") {
+			f.discard()
 		}
+	}()
 
-		if err := os.WriteFile(fullPath, data, 0644); err != nil {
-			return fmt.Errorf("failed to write file %s: %w", fullPath, err)
+	hash := sha256.New()
+	ack := "OK\n"
+	if offset > 0 {
+		if _, err := io.Copy(hash, io.NewSectionReader(f, 0, offset)); err != nil {
+			return fmt.Errorf("failed to read partial file %s: %w", outputPath, err)
 		}
+		ack = fmt.Sprintf("RESUME %d\n", offset)
+		log.Printf("Resuming %s from offset %d", outputPath, offset)
+	}
+	if _, err := conn.Write([]byte(ack)); err != nil {
+		return fmt.Errorf("failed to ack file %s: %w", outputPath, err)
+	}
 
-		log.Printf("File written: %s (%d bytes)", fullPath, len(data))
+	received, err := pkg.ReceiveChunks(io.MultiWriter(f, hash), reader)
+	bytesReceivedTotal.Add(float64(received))
+	if err != nil {
+		if errors.Is(err, pkg.ErrChunkChecksum) {
+			checksumFailuresTotal.Inc()
+		}
+		return fmt.Errorf("failed to receive file %s: %w", outputPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to flush file %s: %w", outputPath, err)
+	}
+	if offset+received != meta.Size {
+		checksumFailuresTotal.Inc()
+		return fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", outputPath, meta.Size, offset+received)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != meta.Checksum {
+		checksumFailuresTotal.Inc()
+		return fmt.Errorf("checksum error for %s: expected %s, got %s", outputPath, meta.Checksum, got)
 	}
 
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", outputPath, err)
+	}
+	if err := os.Rename(f.Name(), outputPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", outputPath, err)
+	}
+	if f.manifest != "" {
+		os.Remove(f.manifest)
+	}
+	syncDir(filepath.Dir(outputPath))
 	return nil
 }
+
+// syncDir flushes dir so a file just renamed into it survives a crash. Not
+// every platform can sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// part is the file a download is written to until it is complete.
+type part struct {
+	*os.File
+	manifest string // path of its manifest, "" if the download cannot be resumed
+}
+
+// discard removes the partial data and its manifest.
+func (p *part) discard() {
+	os.Remove(p.Name())
+	if p.manifest != "" {
+		os.Remove(p.manifest)
+	}
+}
+
+// openPart opens the .part file for outputPath, a path below root, positioned
+// at its end and returns the offset to resume from. Partial data is only
+// reused when its manifest matches meta, otherwise a fresh .part file and
+// manifest are created. Either file is refused if it is not a regular file.
+// A .part name taken by a file without a manifest belongs to someone else and
+// is left alone; the data then goes to a temporary file that is not resumed.
+func openPart(root, outputPath string, meta config.Meta) (*part, int64, error) {
+	partPath, err := sidecarPath(root, outputPath, partSuffix)
+	if err != nil {
+		return nil, 0, err
+	}
+	manifestPath, err := sidecarPath(root, outputPath, manifestSuffix)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	want := partManifest{Size: meta.Size, Checksum: meta.Checksum}
+	if manifest, ok := readManifest(manifestPath); ok {
+		// Both files were left by an earlier transfer.
+		if manifest == want {
+			if f, err := os.OpenFile(partPath, os.O_RDWR, 0644); err == nil {
+				offset, err := f.Seek(0, io.SeekEnd)
+				if err == nil && offset <= meta.Size {
+					return &part{File: f, manifest: manifestPath}, offset, nil
+				}
+				f.Close()
+			}
+		}
+		os.Remove(partPath)
+		os.Remove(manifestPath)
+	}
+
+	err = createManifest(manifestPath, want)
+	if err == nil {
+		f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return &part{File: f, manifest: manifestPath}, 0, nil
+		}
+		os.Remove(manifestPath)
+		if !errors.Is(err, os.ErrExist) {
+			return nil, 0, fmt.Errorf("failed to create file %s: %w", partPath, err)
+		}
+	} else if !errors.Is(err, os.ErrExist) {
+		return nil, 0, fmt.Errorf("failed to write manifest for %s: %w", outputPath, err)
+	}
+
+	log.Printf("%s is taken, receiving %s without resume", partPath, outputPath)
+	f, err := os.CreateTemp(filepath.Dir(outputPath), filepath.Base(outputPath)+".*"+partSuffix)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create file for %s: %w", outputPath, err)
+	}
+	f.Chmod(0644)
+	return &part{File: f}, 0, nil
+}
+
+// readManifest reads the manifest at path and reports whether it is one that
+// openPart wrote.
+func readManifest(path string) (partManifest, bool) {
+	var manifest partManifest
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &manifest) != nil {
+		return manifest, false
+	}
+	return manifest, manifest.Checksum != ""
+}
+
+// createManifest writes manifest to path, failing with os.ErrExist if the
+// name is taken.
+func createManifest(path string, manifest partManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
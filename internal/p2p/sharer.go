@@ -1,8 +1,10 @@
 package p2p
 
 import (
-	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -12,31 +14,53 @@ import (
 	"time"
 
 	"github.com/sujalshah-bit/DirectDrop/internal/config"
+	"github.com/sujalshah-bit/DirectDrop/internal/secure"
 	"github.com/sujalshah-bit/DirectDrop/pkg"
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
 )
 
 type SharerTCPServer struct {
 	Addr       string // Note that this address is of another server.
 	PeerAddr   string
 	Timeout    time.Duration
+	TLSConfig  *tls.Config   // when set, the rendezvous server is reached over TLS
+	Words      int           // number of words in generated share codes
+	KeepAlive  time.Duration // how often the registration is refreshed
+	MaxUses    int           // downloads allowed before the share closes, 0 for unlimited
+	Expires    time.Time     // when the share closes, zero for never
 	listener   net.Listener
 	clients    map[net.Conn]bool
 	clientsMux sync.Mutex
 	codes      map[string]string // Store codes: code -> value
+	nameplate  string            // public part of the active code, known to the server
+	secret     string            // secret part of the active code, keys the encryption
+	token      string            // owner token the server returned for the nameplate
+	failures   int               // handshakes that failed because of a wrong code
+	downloads  int               // completed downloads
+	reserved   int               // downloads in progress, see reserve
 	codesMux   sync.Mutex
+	relayConn  net.Conn // standby connection parked on the server's relay
+	relayMux   sync.Mutex
 	flags      []*string
 	wg         sync.WaitGroup
+	done       chan struct{} // closed when the share starts stopping
+	stopped    chan struct{} // closed once it has stopped
+	stopOnce   sync.Once
 }
 
 // NewSharerTCPServer creates a new TCP server instance
 func NewSharerTCPServer(peerAddr, addr string, timeout time.Duration, flags []*string) *SharerTCPServer {
 	return &SharerTCPServer{
-		Addr:     addr,
-		PeerAddr: peerAddr,
-		Timeout:  timeout,
-		clients:  make(map[net.Conn]bool),
-		codes:    make(map[string]string),
-		flags:    flags,
+		Addr:      addr,
+		PeerAddr:  peerAddr,
+		Timeout:   timeout,
+		Words:     2,
+		KeepAlive: config.KEEPALIVE * time.Second,
+		clients:   make(map[net.Conn]bool),
+		codes:     make(map[string]string),
+		flags:     flags,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
 	}
 }
 
@@ -48,7 +72,11 @@ func (s *SharerTCPServer) Start() error {
 	}
 	s.listener = listener
 
-	log.Printf("TCP Server started on %s\n", s.PeerAddr)
+	log.Printf("TCP Server started on %s\n", listener.Addr())
+
+	if !s.Expires.IsZero() {
+		go s.expire()
+	}
 
 	// Start accepting connections
 	go s.acceptConnections()
@@ -56,14 +84,63 @@ func (s *SharerTCPServer) Start() error {
 	return nil
 }
 
-// Stop stops the TCP server
+// AdvertiseAddr returns the address receivers should dial, built from the
+// address the listener actually bound so ephemeral ports (":0") work. An
+// unspecified host is replaced by the device's outbound IP.
+func (s *SharerTCPServer) AdvertiseAddr() string {
+	host, port, err := net.SplitHostPort(s.listener.Addr().String())
+	if err != nil {
+		return s.listener.Addr().String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = pkg.GetDeviceIP()
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Done returns a channel that is closed once the share has stopped, either
+// through Stop or because too many receivers presented a wrong code.
+func (s *SharerTCPServer) Done() <-chan struct{} {
+	return s.stopped
+}
+
+// Stop unregisters the share code from the rendezvous server so receivers do
+// not get a dead address, then stops the TCP server and waits for the
+// connections it was serving to close.
 func (s *SharerTCPServer) Stop() error {
+	first := false
+	s.stopOnce.Do(func() {
+		close(s.done)
+		first = true
+	})
+	if !first {
+		return nil
+	}
+
+	if err := s.unregister(); err != nil {
+		log.Printf("Failed to unregister share code: %v", err)
+	}
+
+	s.relayMux.Lock()
+	if s.relayConn != nil {
+		s.relayConn.Close()
+	}
+	s.relayMux.Unlock()
+
+	var err error
 	if s.listener != nil {
-		log.Fatal("Closing Sharer TCP server")
-		return s.listener.Close()
+		log.Println("Closing Sharer TCP server")
+		err = s.listener.Close()
+	}
+
+	s.clientsMux.Lock()
+	for conn := range s.clients {
+		conn.Close()
 	}
+	s.clientsMux.Unlock()
 	s.wg.Wait()
-	return nil
+	close(s.stopped)
+	return err
 }
 
 // acceptConnections accepts incoming connections
@@ -79,18 +156,92 @@ func (s *SharerTCPServer) acceptConnections() {
 			continue
 		}
 
-		s.clientsMux.Lock()
-		s.clients[conn] = true
-		s.clientsMux.Unlock()
-
 		log.Printf("Waiting for connection....\n")
 
-		s.wg.Add(1)
-		// Handle each client in a separate goroutine
-		go s.handleClient(conn)
+		s.serveClient(conn)
+	}
+}
+
+// serveClient tracks conn and handles it in a separate goroutine
+func (s *SharerTCPServer) serveClient(conn net.Conn) {
+	s.clientsMux.Lock()
+	s.clients[conn] = true
+	s.clientsMux.Unlock()
+
+	s.wg.Add(1)
+	go s.handleClient(conn)
+}
+
+// StartRelay keeps a standby connection parked on the rendezvous server's
+// relay so receivers that cannot reach this sharer directly, e.g. because it
+// is behind NAT or a firewall, get spliced through the server instead. It
+// must be called after SendCodeToServer and stops by itself if the server
+// does not offer a relay.
+func (s *SharerTCPServer) StartRelay() {
+	go s.serveRelay()
+}
+
+func (s *SharerTCPServer) serveRelay() {
+	for {
+		conn, err := s.relayStandby()
+		select {
+		case <-s.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		default:
+		}
+
+		if errors.Is(err, rendezvous.ErrRelayDisabled) {
+			log.Printf("Server %s does not offer a relay", s.Addr)
+			return
+		}
+		if errors.Is(err, rendezvous.ErrRevoked) {
+			s.revoked()
+			return
+		}
+		if err != nil {
+			// The server drops idle standbys, which just means renewing it.
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Relay standby failed: %v", err)
+			}
+			select {
+			case <-s.done:
+				return
+			case <-time.After(config.RETRY_DELAY * time.Second):
+			}
+			continue
+		}
+
+		log.Printf("Receiver connected through relay")
+		s.serveClient(conn)
+	}
+}
+
+// relayStandby parks a connection on the relay and blocks until the server
+// pairs a receiver with it.
+func (s *SharerTCPServer) relayStandby() (net.Conn, error) {
+	s.codesMux.Lock()
+	nameplate, token := s.nameplate, s.token
+	s.codesMux.Unlock()
+
+	conn, server, err := rendezvous.Dial(s.Addr, s.Timeout, s.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.relayMux.Lock()
+	s.relayConn = conn
+	s.relayMux.Unlock()
 
+	_, err = server.Do(rendezvous.Request{Type: rendezvous.TypeRelay, Code: nameplate, Token: token, Role: rendezvous.RoleShare})
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
+	return conn, nil
 }
+
 func (s *SharerTCPServer) handleClient(conn net.Conn) {
 	defer func() {
 		conn.Close()
@@ -103,8 +254,148 @@ func (s *SharerTCPServer) handleClient(conn net.Conn) {
 	clientAddr := conn.RemoteAddr().String()
 	log.Printf("Client connected: %s\n", clientAddr)
 
-	if err := s.shareObject(conn); err != nil {
+	if !s.available() {
+		log.Printf("Refusing client %s: the share has no downloads left or has expired", clientAddr)
+		return
+	}
+
+	start := time.Now()
+	secureConn, err := s.handshake(conn)
+	if err != nil {
+		log.Printf("Handshake with client %s failed: %v", clientAddr, err)
+		handshakeFailuresTotal.Inc()
+		if errors.Is(err, secure.ErrWrongCode) {
+			s.handshakeFailed()
+		}
+		return
+	}
+
+	// Refused after the handshake, so the receiver can trust the reason and
+	// does not retry.
+	if !s.reserve() {
+		log.Printf("Refusing client %s: the remaining downloads are in progress", clientAddr)
+		pkg.SendRefusal(secureConn, "the share has no downloads left")
+		return
+	}
+	if err := s.shareObject(secureConn); err != nil {
 		log.Printf("Error handling client %s: %v", clientAddr, err)
+		transfersTotal.With("failed").Inc()
+		s.release()
+		return
+	}
+	transfersTotal.With("ok").Inc()
+	transferSeconds.Observe(time.Since(start).Seconds())
+	s.downloadDone()
+}
+
+// available reports whether the share still accepts receivers.
+func (s *SharerTCPServer) available() bool {
+	if !s.Expires.IsZero() && time.Now().After(s.Expires) {
+		return false
+	}
+	s.codesMux.Lock()
+	defer s.codesMux.Unlock()
+	return s.MaxUses == 0 || s.downloads < s.MaxUses
+}
+
+// reserve claims one of the remaining downloads for an accepted receiver, so
+// concurrent receivers cannot exceed MaxUses. It reports false if none is
+// left. The claim ends with downloadDone, or release if the transfer fails.
+func (s *SharerTCPServer) reserve() bool {
+	if !s.Expires.IsZero() && time.Now().After(s.Expires) {
+		return false
+	}
+	s.codesMux.Lock()
+	defer s.codesMux.Unlock()
+	if s.MaxUses > 0 && s.downloads+s.reserved >= s.MaxUses {
+		return false
+	}
+	s.reserved++
+	return true
+}
+
+// release gives back a download claimed by reserve for a transfer that
+// failed or was declined.
+func (s *SharerTCPServer) release() {
+	s.codesMux.Lock()
+	s.reserved--
+	s.codesMux.Unlock()
+}
+
+// downloadDone counts a completed download claimed by reserve and closes the
+// share once it has been downloaded MaxUses times.
+func (s *SharerTCPServer) downloadDone() {
+	s.codesMux.Lock()
+	s.reserved--
+	s.downloads++
+	downloads := s.downloads
+	s.codesMux.Unlock()
+
+	if s.MaxUses > 0 && downloads >= s.MaxUses {
+		log.Printf("Share was downloaded %d times, closing it", downloads)
+		go s.Stop()
+	}
+}
+
+// expire closes the share once Expires has passed.
+func (s *SharerTCPServer) expire() {
+	timer := time.NewTimer(time.Until(s.Expires))
+	defer timer.Stop()
+
+	select {
+	case <-s.done:
+	case <-timer.C:
+		log.Printf("Share expired, closing it")
+		s.Stop()
+	}
+}
+
+// addRequest returns the ADD request registering nameplate with the share
+// limits, so the rendezvous server stops handing out the address once they
+// are reached.
+func (s *SharerTCPServer) addRequest(nameplate, token string) rendezvous.Request {
+	return rendezvous.Request{
+		Type:    rendezvous.TypeAdd,
+		Code:    nameplate,
+		Address: s.AdvertiseAddr(),
+		Token:   token,
+		Uses:    s.MaxUses,
+		Expires: s.Expires,
+	}
+}
+
+// handshake authenticates the receiver with the secret part of the share code
+// and returns the encrypted connection used for the rest of the transfer.
+func (s *SharerTCPServer) handshake(conn net.Conn) (net.Conn, error) {
+	s.codesMux.Lock()
+	secret := s.secret
+	s.codesMux.Unlock()
+	if secret == "" {
+		return nil, fmt.Errorf("no share code registered yet")
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return nil, err
+	}
+	secureConn, err := secure.Handshake(conn, secret, false)
+	if err != nil {
+		return nil, err
+	}
+	return secureConn, conn.SetDeadline(time.Time{})
+}
+
+// handshakeFailed counts a receiver that presented a wrong code. Word codes
+// carry few bits, so the share is closed after config.MAX_FAILURES attempts
+// rather than letting anyone keep guessing online.
+func (s *SharerTCPServer) handshakeFailed() {
+	s.codesMux.Lock()
+	s.failures++
+	failures := s.failures
+	s.codesMux.Unlock()
+
+	if failures >= config.MAX_FAILURES {
+		log.Printf("%d receivers used a wrong code, closing the share", failures)
+		go s.Stop()
 	}
 }
 
@@ -115,8 +406,13 @@ func (s *SharerTCPServer) shareObject(conn net.Conn) error {
 		return fmt.Errorf("failed to check path type: %w", err)
 	}
 
+	manifest, err := buildManifest(path, ok)
+	if err != nil {
+		return err
+	}
+
 	if ok {
-		meta := config.Meta{Type: "dir"}
+		meta := config.Meta{Type: "dir", Manifest: manifest}
 		if err := pkg.SendMetadata(conn, meta); err != nil {
 			return err
 		}
@@ -126,7 +422,7 @@ func (s *SharerTCPServer) shareObject(conn net.Conn) error {
 		return s.shareFolder(conn)
 	}
 
-	meta := config.Meta{Type: "file"}
+	meta := config.Meta{Type: "file", Manifest: manifest}
 	if err := pkg.SendMetadata(conn, meta); err != nil {
 		return err
 	}
@@ -136,49 +432,95 @@ func (s *SharerTCPServer) shareObject(conn net.Conn) error {
 	return s.shareFile(conn)
 }
 
-func (s *SharerTCPServer) shareFile(conn net.Conn) error {
-	path := *s.flags[config.PATH]
-	data, err := os.ReadFile(path)
+// buildManifest summarises the file or folder at path for the receiver's
+// consent prompt.
+func buildManifest(path string, isDir bool) (*config.Manifest, error) {
+	manifest := &config.Manifest{Name: filepath.Base(path)}
+	if !isDir {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		manifest.Files, manifest.Size = 1, info.Size()
+		return manifest, nil
+	}
+
+	entries, err := os.ReadDir(path)
 	if err != nil {
-		return fmt.Errorf("failed to read file %s: %w", path, err)
+		return nil, fmt.Errorf("failed to list folder: %w", err)
+	}
+	for i, entry := range entries {
+		if i == config.MAX_MANIFEST_ENTRIES {
+			manifest.More = len(entries) - i
+			break
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		manifest.Entries = append(manifest.Entries, name)
 	}
 
-	compressed, checksum, err := pkg.CompressData(data)
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case p == path:
+		case info.IsDir():
+			manifest.Dirs++
+		case info.Mode()&os.ModeSymlink != 0:
+			manifest.Files++
+		default:
+			manifest.Files++
+			manifest.Size += info.Size()
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to compress file %s: %w", path, err)
+		return nil, fmt.Errorf("failed to summarise folder: %w", err)
 	}
+	return manifest, nil
+}
 
+// shareFile sends the shared file and waits for the receiver to confirm it
+// has the file in place, so a download cut short does not count.
+func (s *SharerTCPServer) shareFile(conn net.Conn) error {
+	path := *s.flags[config.PATH]
 	meta := config.Meta{
 		Filename: filepath.Base(path),
 		Type:     "file",
-		Size:     len(compressed),
-		Checksum: checksum,
 	}
-	if err := pkg.SendMetadata(conn, meta); err != nil {
+	if err := s.sendFile(conn, path, meta); err != nil {
 		return err
 	}
 	if err := pkg.WaitAck(conn); err != nil {
-		return fmt.Errorf("receiver rejected file %s: %w", path, err)
-	}
-
-	if _, err := conn.Write(compressed); err != nil {
-		return fmt.Errorf("failed to send file %s: %w", path, err)
+		return fmt.Errorf("receiver did not confirm the file: %w", err)
 	}
-	log.Printf("Sent file %s (%d bytes)", path, len(compressed))
 	return nil
 }
 
+// shareFolder sends every entry below the folder and then an end message,
+// which the receiver acks once it has the whole folder.
 func (s *SharerTCPServer) shareFolder(conn net.Conn) error {
 	basePath := *s.flags[config.PATH]
 
-	return filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		relPath, _ := filepath.Rel(basePath, path)
+		relPath = filepath.ToSlash(relPath)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return sendSymlink(conn, basePath, path, relPath)
+		}
 
 		if info.IsDir() {
 			meta := config.Meta{Path: relPath, Type: "dir"}
+			if relPath != "." {
+				meta.Mode, meta.ModTime = info.Mode().Perm(), info.ModTime()
+			}
 			if err := pkg.SendMetadata(conn, meta); err != nil {
 				return err
 			}
@@ -189,75 +531,313 @@ func (s *SharerTCPServer) shareFolder(conn net.Conn) error {
 			return nil
 		}
 
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read file %s: %w", relPath, err)
-		}
+		meta := config.Meta{Path: relPath, Type: "file"}
+		return s.sendFile(conn, path, meta)
+	})
+	if err != nil {
+		return err
+	}
+	if err := pkg.SendMetadata(conn, config.Meta{Type: config.END}); err != nil {
+		return err
+	}
+	if err := pkg.WaitAck(conn); err != nil {
+		return fmt.Errorf("receiver did not ack end of folder: %w", err)
+	}
+	return nil
+}
 
-		compressed, checksum, err := pkg.CompressData(data)
-		if err != nil {
-			return fmt.Errorf("failed to compress %s: %w", relPath, err)
-		}
+// sendSymlink announces the symlink at path, relPath within the shared folder
+// at basePath. Only links that stay within the folder are sent, as relative
+// links so they keep working wherever the folder is received; others are
+// skipped, since following them could share files outside the folder.
+func sendSymlink(conn net.Conn, basePath, path, relPath string) error {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %s: %w", relPath, err)
+	}
+	link, ok := linkWithin(basePath, path, target)
+	if !ok {
+		log.Printf("Skipping symlink %s: %s is outside the shared folder", relPath, target)
+		return nil
+	}
 
-		meta := config.Meta{
-			Path:     relPath,
-			Type:     "file",
-			Size:     len(compressed),
-			Checksum: checksum,
-		}
-		if err := pkg.SendMetadata(conn, meta); err != nil {
-			return err
-		}
-		if err := pkg.WaitAck(conn); err != nil {
-			return fmt.Errorf("receiver rejected file %s: %w", relPath, err)
-		}
+	meta := config.Meta{Path: relPath, Type: config.SYMLINK, Link: filepath.ToSlash(link)}
+	if err := pkg.SendMetadata(conn, meta); err != nil {
+		return err
+	}
+	if err := pkg.WaitAck(conn); err != nil {
+		return fmt.Errorf("receiver rejected symlink %s: %w", relPath, err)
+	}
+	log.Printf("Sent symlink: %s -> %s", relPath, link)
+	return nil
+}
 
-		if _, err := conn.Write(compressed); err != nil {
-			return fmt.Errorf("failed to send file %s: %w", relPath, err)
-		}
-		log.Printf("Sent file: %s (%d bytes)", relPath, len(compressed))
+// linkWithin returns target, the target of the symlink at path, relative to
+// the link's directory, and whether it stays within basePath.
+func linkWithin(basePath, path, target string) (string, bool) {
+	base, err := filepath.Abs(basePath)
+	if err != nil {
+		return "", false
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return "", false
+	}
+	resolved := target
+	if !filepath.IsAbs(target) {
+		resolved = filepath.Join(dir, target)
+	}
+	if !within(base, resolved) {
+		return "", false
+	}
+	link, err := filepath.Rel(dir, resolved)
+	return link, err == nil
+}
+
+// sendFile announces the file at path with its size and checksum and, once the
+// receiver acks, streams its contents in chunks so memory use stays bounded
+// regardless of the file size. The receiver may answer with a resume offset
+// in which case only the remainder of the file is sent.
+func (s *SharerTCPServer) sendFile(conn net.Conn, path string, meta config.Meta) error {
+	name := meta.Filename
+	if meta.Path != "" {
+		name = meta.Path
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", name, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", name, err)
+	}
+	checksum, err := pkg.CalculateChecksum(f)
+	if err != nil {
+		return fmt.Errorf("failed to checksum file %s: %w", name, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind file %s: %w", name, err)
+	}
+
+	meta.Size = info.Size()
+	meta.Checksum = checksum
+	meta.Mode, meta.ModTime = info.Mode().Perm(), info.ModTime()
+	if err := pkg.SendMetadata(conn, meta); err != nil {
+		return err
+	}
+	offset, err := pkg.ReadAck(conn)
+	if errors.Is(err, pkg.ErrSkipped) {
+		log.Printf("Receiver skipped file: %s", name)
 		return nil
-	})
+	}
+	if err != nil {
+		return fmt.Errorf("receiver rejected file %s: %w", name, err)
+	}
+	if offset > meta.Size {
+		return fmt.Errorf("receiver asked to resume %s at offset %d past its size %d", name, offset, meta.Size)
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek file %s: %w", name, err)
+		}
+		log.Printf("Resuming file: %s at offset %d", name, offset)
+	}
+
+	sent, err := pkg.SendChunks(conn, f)
+	bytesSentTotal.Add(float64(sent))
+	if err != nil {
+		return fmt.Errorf("failed to send file %s: %w", name, err)
+	}
+	log.Printf("Sent file: %s (%d bytes)", name, sent)
+	return nil
 }
 
 // ==================== CLIENT FUNCTIONALITY TO OTHER SERVERS ====================
 
-// SendCodeToServer sends an ADD command to another server (acts as client)
-func (s *SharerTCPServer) SendCodeToServer() error {
-	conn, err := net.DialTimeout("tcp", s.Addr, s.Timeout)
+// SendCodeToServer registers the share with the rendezvous server with an ADD
+// request (acting as a client) and returns its code. The server allocates a
+// free nameplate; servers older than protocol version 2 are offered random
+// ones instead, and a fresh one is tried if it is taken by another share.
+func (s *SharerTCPServer) SendCodeToServer() (string, error) {
+	conn, server, err := rendezvous.Dial(s.Addr, s.Timeout, s.TLSConfig)
 	if err != nil {
-		return fmt.Errorf("failed to connect to server %s: %w", s.Addr, err)
+		return "", err
 	}
 	defer conn.Close()
 
-	addr := pkg.GetDeviceIPWithPort("8081")
+	// Only the nameplate is registered; the secret stays with the peers.
+	secret := pkg.GenerateSecret(s.Words)
+	for attempt := 1; ; attempt++ {
+		nameplate := ""
+		if server.Version < 2 {
+			nameplate = pkg.GenerateNameplate()
+		}
+
+		if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+			return "", err
+		}
+		resp, err := server.Do(s.addRequest(nameplate, ""))
+		if errors.Is(err, rendezvous.ErrCodeInUse) && nameplate != "" && attempt < config.RETRIES {
+			log.Printf("Code %s is taken, trying another one", nameplate)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("server %s rejected code: %w", s.Addr, err)
+		}
+		if nameplate == "" {
+			if nameplate = resp.Code; nameplate == "" {
+				return "", fmt.Errorf("server %s allocated no code", s.Addr)
+			}
+		}
+		code := nameplate + "-" + secret
+
+		// Store the code locally as well
+		s.codesMux.Lock()
+		s.codes[code] = "shared_with_server"
+		s.nameplate = nameplate
+		s.secret = secret
+		s.token = resp.Token
+		s.codesMux.Unlock()
+
+		log.Printf("Code sent to server %s: %s\n", s.Addr, code)
+		return code, nil
+	}
+}
+
+// StartKeepAlive refreshes the registration on the rendezvous server every
+// KeepAlive so it outlives the server's stale peer TTL for as long as the
+// share runs. A registration the server has already dropped is claimed again
+// with the owner token; if that is no longer possible the share is stopped,
+// since receivers could not find it anyway. It must be called after
+// SendCodeToServer.
+func (s *SharerTCPServer) StartKeepAlive() {
+	go s.keepAlive()
+}
 
-	code := pkg.GenerateRandomString(10)
+func (s *SharerTCPServer) keepAlive() {
+	ticker := time.NewTicker(s.KeepAlive)
+	defer ticker.Stop()
 
-	_, err = fmt.Fprintf(conn, "ADD %s %s\n", code, addr)
-	if err != nil {
-		return fmt.Errorf("failed to send code to server %s: %w", s.Addr, err)
+	failing := false
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		err := s.ping()
+		if errors.Is(err, rendezvous.ErrRevoked) {
+			s.revoked()
+			return
+		}
+		if errors.Is(err, errCodeLapsed) {
+			log.Printf("Registration of the share code lapsed on server %s, registering it again", s.Addr)
+			err = s.register()
+			if errors.Is(err, errCodeTaken) {
+				log.Printf("Share code was taken by another share, receivers can no longer find this one")
+				s.codesMux.Lock()
+				s.token = "" // the registration is no longer ours to delete
+				s.codesMux.Unlock()
+				s.Stop()
+				return
+			}
+		}
+		switch {
+		case err != nil && !failing:
+			log.Printf("Failed to refresh share code on server %s, receivers may not find this share: %v", s.Addr, err)
+			failing = true
+		case err == nil && failing:
+			log.Printf("Share code refreshed on server %s again", s.Addr)
+			failing = false
+		}
 	}
+}
 
-	// Read response from server
-	reader := bufio.NewReader(conn)
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		return fmt.Errorf("failed to read response from server %s: %w", s.Addr, err)
+// revoked stops a share whose code an operator revoked on the server.
+func (s *SharerTCPServer) revoked() {
+	select {
+	case <-s.done:
+		return // already stopping
+	default:
 	}
+	log.Printf("Share code was revoked on server %s, stopping the share", s.Addr)
+	s.Stop()
+}
+
+var (
+	errCodeLapsed = errors.New("registration lapsed")
+	errCodeTaken  = errors.New("code in use")
+)
 
-	response = strings.TrimSpace(response)
-	log.Printf("Server %s responded: %s\n", s.Addr, response)
+// ping refreshes the registration with a PING request.
+func (s *SharerTCPServer) ping() error {
+	s.codesMux.Lock()
+	nameplate, token, downloads := s.nameplate, s.token, s.downloads
+	s.codesMux.Unlock()
+
+	err := s.request(rendezvous.Request{Type: rendezvous.TypePing, Code: nameplate, Token: token, Downloads: downloads})
+	if errors.Is(err, rendezvous.ErrNotFound) {
+		return errCodeLapsed
+	}
+	return err
+}
 
-	// Store the code locally as well
+// register registers the current nameplate again under the owner token.
+func (s *SharerTCPServer) register() error {
 	s.codesMux.Lock()
-	s.codes[code] = "shared_with_server"
+	nameplate, token := s.nameplate, s.token
 	s.codesMux.Unlock()
 
-	log.Printf("Code sent to server %s: %s\n", s.Addr, code)
+	err := s.request(s.addRequest(nameplate, token))
+	if errors.Is(err, rendezvous.ErrCodeInUse) || errors.Is(err, rendezvous.ErrInvalidToken) {
+		return errCodeTaken
+	}
+	return err
+}
+
+// unregister removes the registration with a DEL request. It does nothing if
+// no code was registered.
+func (s *SharerTCPServer) unregister() error {
+	s.codesMux.Lock()
+	nameplate, token := s.nameplate, s.token
+	s.codesMux.Unlock()
+	if token == "" {
+		return nil
+	}
+
+	err := s.request(rendezvous.Request{Type: rendezvous.TypeDel, Code: nameplate, Token: token})
+	if errors.Is(err, rendezvous.ErrNotFound) || errors.Is(err, rendezvous.ErrRevoked) {
+		// Already gone, e.g. collected after the keep-alive failed, or
+		// revoked and left for the server to collect.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Unregistered share code from server %s", s.Addr)
 	return nil
 }
 
+// request sends a single request to the rendezvous server on a new
+// connection.
+func (s *SharerTCPServer) request(req rendezvous.Request) error {
+	conn, server, err := rendezvous.Dial(s.Addr, s.Timeout, s.TLSConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return err
+	}
+	_, err = server.Do(req)
+	return err
+}
+
 // GetCodes returns all stored codes (for debugging/monitoring)
 func (s *SharerTCPServer) GetCodes() map[string]string {
 	s.codesMux.Lock()
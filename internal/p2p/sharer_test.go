@@ -0,0 +1,216 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+// fakeServer answers rendezvous requests with respond and records them. HELLO
+// is answered by the fake itself.
+type fakeServer struct {
+	mu       sync.Mutex
+	requests []rendezvous.Request
+	respond  func(req rendezvous.Request) rendezvous.Response
+}
+
+func (f *fakeServer) start(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (f *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req rendezvous.Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return
+		}
+		if req.Type == rendezvous.TypeHello {
+			rendezvous.Write(conn, rendezvous.Response{Type: req.Type, Version: rendezvous.Version})
+			continue
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		resp := f.respond(req)
+		f.mu.Unlock()
+		rendezvous.Write(conn, resp)
+	}
+}
+
+// received counts the requests of type typ.
+func (f *fakeServer) received(typ string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, req := range f.requests {
+		if req.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func failWith(err *rendezvous.Error) rendezvous.Response {
+	return rendezvous.Response{Type: rendezvous.TypeError, Error: err}
+}
+
+func TestKeepAliveReregisters(t *testing.T) {
+	registered := true
+	server := &fakeServer{respond: func(req rendezvous.Request) rendezvous.Response {
+		switch {
+		case req.Type == rendezvous.TypePing:
+			if !registered {
+				return failWith(rendezvous.ErrNotFound)
+			}
+			// The server dropped the registration after the first ping.
+			registered = false
+			return rendezvous.Response{Type: req.Type}
+		case req.Type == rendezvous.TypeAdd && req.Code == "7" && req.Token == "t0k3n":
+			return rendezvous.Response{Type: req.Type, Token: req.Token, Updated: true}
+		}
+		return failWith(rendezvous.ErrBadRequest)
+	}}
+
+	s := NewSharerTCPServer("127.0.0.1:0", server.start(t), time.Second, nil)
+	s.KeepAlive = 20 * time.Millisecond
+	s.nameplate, s.token = "7", "t0k3n"
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	s.StartKeepAlive()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for server.received(rendezvous.TypeAdd) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.received(rendezvous.TypeAdd) == 0 {
+		t.Fatal("lapsed registration was not renewed")
+	}
+}
+
+func TestKeepAliveStopsWhenCodeIsTaken(t *testing.T) {
+	server := &fakeServer{respond: func(req rendezvous.Request) rendezvous.Response {
+		switch req.Type {
+		case rendezvous.TypePing:
+			return failWith(rendezvous.ErrNotFound)
+		case rendezvous.TypeAdd:
+			return failWith(rendezvous.ErrInvalidToken)
+		}
+		return failWith(rendezvous.ErrBadRequest)
+	}}
+
+	s := NewSharerTCPServer("127.0.0.1:0", server.start(t), time.Second, nil)
+	s.KeepAlive = 20 * time.Millisecond
+	s.nameplate, s.token = "7", "t0k3n"
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.StartKeepAlive()
+
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("share kept running after its code was taken")
+	}
+	if server.received(rendezvous.TypeDel) != 0 {
+		t.Errorf("sharer deleted a registration it no longer owns")
+	}
+}
+
+func TestStopUnregisters(t *testing.T) {
+	server := &fakeServer{respond: func(req rendezvous.Request) rendezvous.Response {
+		if req.Type == rendezvous.TypeDel && req.Code == "7" && req.Token == "t0k3n" {
+			return rendezvous.Response{Type: req.Type, Code: req.Code}
+		}
+		return failWith(rendezvous.ErrBadRequest)
+	}}
+
+	s := NewSharerTCPServer("127.0.0.1:0", server.start(t), time.Second, nil)
+	s.nameplate, s.token = "7", "t0k3n"
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if server.received(rendezvous.TypeDel) != 1 {
+		t.Errorf("Stop did not unregister the code")
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Errorf("Done not closed after Stop")
+	}
+	// Stopping twice is harmless and does not unregister again.
+	s.Stop()
+	if server.received(rendezvous.TypeDel) != 1 {
+		t.Errorf("second Stop unregistered again")
+	}
+}
+
+func TestReceiveCodeNotFound(t *testing.T) {
+	server := &fakeServer{respond: func(req rendezvous.Request) rendezvous.Response {
+		if req.Type == rendezvous.TypeLook && req.Code == "7" {
+			return rendezvous.Response{Type: req.Type, Code: req.Code, Address: "10.0.0.1:9000"}
+		}
+		return failWith(rendezvous.ErrNotFound)
+	}}
+	addr := server.start(t)
+
+	client := NewTCPClient(addr, time.Second)
+	defer client.Close()
+	if got, err := client.ReceiveCode("7-apple-zoo"); err != nil || got != "10.0.0.1:9000" {
+		t.Errorf("ReceiveCode = %q, %v", got, err)
+	}
+	if _, err := client.ReceiveCode("8-apple-zoo"); err != ErrCodeNotFound {
+		t.Errorf("expected ErrCodeNotFound, got %v", err)
+	}
+}
+
+func TestKeepAliveStopsWhenRevoked(t *testing.T) {
+	server := &fakeServer{respond: func(rendezvous.Request) rendezvous.Response {
+		return failWith(rendezvous.ErrRevoked)
+	}}
+
+	s := NewSharerTCPServer("127.0.0.1:0", server.start(t), time.Second, nil)
+	s.KeepAlive = 20 * time.Millisecond
+	s.nameplate, s.token = "7", "t0k3n"
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.StartKeepAlive()
+
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("share kept running after its code was revoked")
+	}
+	if server.received(rendezvous.TypeAdd) != 0 {
+		t.Errorf("sharer registered a revoked code again")
+	}
+	if err := s.unregister(); err != nil {
+		t.Errorf("unregistering a revoked code: %v", err)
+	}
+}
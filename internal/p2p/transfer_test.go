@@ -0,0 +1,648 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/internal/config"
+	"github.com/sujalshah-bit/DirectDrop/internal/secure"
+	"github.com/sujalshah-bit/DirectDrop/pkg"
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+const testSecret = "s3cr3t"
+
+// newTestClient returns a receiver that knows the secret startSharer uses.
+func newTestClient() *TCPClient {
+	client := NewTCPClient("", 5*time.Second)
+	client.secret = testSecret
+	return client
+}
+
+// startSharer shares path on a loopback listener and returns its address.
+// Options are applied to the sharer before it starts.
+func startSharer(t *testing.T, path string, options ...func(*SharerTCPServer)) (*SharerTCPServer, string) {
+	t.Helper()
+	flags := []*string{new(string), new(string), new(string), &path}
+	s := NewSharerTCPServer("127.0.0.1:0", "127.0.0.1:0", 5*time.Second, flags)
+	s.secret = testSecret
+	for _, option := range options {
+		option(s)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start sharer: %v", err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s, s.listener.Addr().String()
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}
+
+func TestTransferFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "image.bin")
+	// Span several chunks and end on a partial one.
+	data := randomBytes(3*pkg.CHUNK_SIZE + 123)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startSharer(t, src)
+
+	t.Chdir(t.TempDir())
+	client := newTestClient()
+	if err := client.RequestData(addr); err != nil {
+		t.Fatalf("RequestData failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join("download", "image.bin"))
+	if err != nil {
+		t.Fatalf("failed to read received file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("received file differs from source (%d vs %d bytes)", len(got), len(data))
+	}
+}
+
+func TestTransferFolder(t *testing.T) {
+	src := t.TempDir()
+	files := map[string][]byte{
+		"a.txt":             []byte("hello"),
+		"empty.txt":         {},
+		"nested/deep/b.bin": randomBytes(pkg.CHUNK_SIZE + 1),
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, addr := startSharer(t, src)
+
+	client := newTestClient()
+	client.TargetDir = t.TempDir()
+	if err := client.RequestData(addr); err != nil {
+		t.Fatalf("RequestData failed: %v", err)
+	}
+
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(client.TargetDir, name))
+		if err != nil {
+			t.Errorf("missing %s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s differs from source", name)
+		}
+	}
+}
+
+func TestTransferResume(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "image.bin")
+	data := randomBytes(2*pkg.CHUNK_SIZE + 77)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startSharer(t, src)
+
+	// Leave behind what an interrupted transfer would have written.
+	target := t.TempDir()
+	output := filepath.Join(target, "download", "image.bin")
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(output+partSuffix, data[:pkg.CHUNK_SIZE+5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(src)
+	checksum, _ := pkg.CalculateChecksum(f)
+	f.Close()
+	manifest, _ := json.Marshal(partManifest{Size: int64(len(data)), Checksum: checksum})
+	if err := os.WriteFile(output+manifestSuffix, manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Chdir(target)
+	client := newTestClient()
+	if err := client.RequestData(addr); err != nil {
+		t.Fatalf("RequestData failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join("download", "image.bin"))
+	if err != nil {
+		t.Fatalf("failed to read received file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("resumed file differs from source")
+	}
+	for _, leftover := range []string{partSuffix, manifestSuffix} {
+		if _, err := os.Stat(filepath.Join("download", "image.bin"+leftover)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be cleaned up", leftover)
+		}
+	}
+}
+
+func TestTransferWrongCode(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(src, []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startSharer(t, src)
+
+	t.Chdir(t.TempDir())
+	client := newTestClient()
+	client.secret = "guess"
+	if err := client.RequestData(addr); !errors.Is(err, secure.ErrWrongCode) {
+		t.Fatalf("expected ErrWrongCode, got %v", err)
+	}
+	if _, err := os.Stat("download"); !os.IsNotExist(err) {
+		t.Errorf("nothing should be written with a wrong code")
+	}
+}
+
+func TestShareClosesAfterWrongCodes(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(src, []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startSharer(t, src)
+
+	t.Chdir(t.TempDir())
+	for i := 0; i < config.MAX_FAILURES; i++ {
+		client := newTestClient()
+		client.secret = "guess"
+		if err := client.RequestData(addr); !errors.Is(err, secure.ErrWrongCode) {
+			t.Fatalf("attempt %d: expected ErrWrongCode, got %v", i+1, err)
+		}
+	}
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("share still open after repeated wrong codes")
+	}
+}
+
+func TestOneTimeShare(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "once.txt")
+	if err := os.WriteFile(src, []byte("only once"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startSharer(t, src, func(s *SharerTCPServer) { s.MaxUses = 1 })
+
+	t.Chdir(t.TempDir())
+	if err := newTestClient().RequestData(addr); err != nil {
+		t.Fatalf("RequestData failed: %v", err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("one-time share still open after its download")
+	}
+	if s.available() {
+		t.Errorf("one-time share still accepts receivers after its download")
+	}
+}
+
+func TestExpiredShareRefuses(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "late.txt")
+	if err := os.WriteFile(src, []byte("too late"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := startSharer(t, src, func(s *SharerTCPServer) { s.Expires = time.Now().Add(50 * time.Millisecond) })
+
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("share still open after it expired")
+	}
+	if s.available() {
+		t.Errorf("expired share still accepts receivers")
+	}
+}
+
+func TestDroppedDownloadDoesNotCount(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "once.txt")
+	if err := os.WriteFile(src, []byte("only once"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, addr := startSharer(t, src, func(s *SharerTCPServer) { s.MaxUses = 1 })
+
+	// Accept the file, then go away without reading any of it once the
+	// sharer had time to hand all of it to the connection.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secureConn, err := secure.Handshake(conn, testSecret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(secureConn)
+	for i := 0; i < 2; i++ {
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			t.Fatal(err)
+		}
+		secureConn.Write([]byte("OK\n"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	client := newTestClient()
+	client.TargetDir = t.TempDir()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := client.RequestData(addr)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrUnavailable) || time.Now().After(deadline) {
+			t.Fatalf("receiving after a dropped download: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("one-time share still open after its download")
+	}
+}
+
+func TestConfirmShowsManifest(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.txt", "nested/b.txt"} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, addr := startSharer(t, src)
+
+	var got *config.Manifest
+	client := newTestClient()
+	client.TargetDir = t.TempDir()
+	client.Confirm = func(manifest *config.Manifest) bool {
+		got = manifest
+		return true
+	}
+	if err := client.RequestData(addr); err != nil {
+		t.Fatalf("RequestData failed: %v", err)
+	}
+
+	want := config.Manifest{Name: filepath.Base(src), Files: 2, Dirs: 1, Size: 10, Entries: []string{"a.txt", "nested/"}}
+	if got == nil || fmt.Sprint(*got) != fmt.Sprint(want) {
+		t.Errorf("manifest = %+v, expected %+v", got, want)
+	}
+}
+
+func TestConfirmDeclined(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sharer, addr := startSharer(t, src, func(s *SharerTCPServer) { s.MaxUses = 1 })
+
+	t.Chdir(t.TempDir())
+	client := newTestClient()
+	client.Confirm = func(*config.Manifest) bool { return false }
+	if err := client.RequestData(addr); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("RequestData returned %v, expected ErrDeclined", err)
+	}
+	if _, err := os.Stat("download"); !os.IsNotExist(err) {
+		t.Errorf("declining wrote to disk: %v", err)
+	}
+	// A declined transfer does not use up the share.
+	if !sharer.available() {
+		t.Error("share closed after a declined transfer")
+	}
+}
+
+func TestConcurrentReceiversShareUses(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "once.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startSharer(t, src, func(s *SharerTCPServer) { s.MaxUses = 1 })
+
+	// The first receiver holds the only download while it decides.
+	asked, answer := make(chan struct{}), make(chan bool)
+	first := newTestClient()
+	first.TargetDir = t.TempDir()
+	first.Confirm = func(*config.Manifest) bool {
+		close(asked)
+		return <-answer
+	}
+	done := make(chan error, 1)
+	go func() { done <- first.RequestData(addr) }()
+	<-asked
+
+	second := newTestClient()
+	second.TargetDir = t.TempDir()
+	if err := second.RequestData(addr); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("receiver beyond the limit got %v, expected ErrUnavailable", err)
+	}
+
+	// Declining gives the download back.
+	answer <- false
+	if err := <-done; !errors.Is(err, ErrDeclined) {
+		t.Fatalf("declining returned %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := second.RequestData(addr)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrUnavailable) || time.Now().After(deadline) {
+			t.Fatalf("receiving after the first receiver declined: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sendToReceiver feeds data to writeFile over a pipe, like a sharer would,
+// and closes the connection without the end frame if cut is set.
+func sendToReceiver(t *testing.T, output string, meta config.Meta, data []byte, cut bool) error {
+	t.Helper()
+	receiver, sharer := net.Pipe()
+	go func() {
+		defer sharer.Close()
+		if _, err := pkg.ReadAck(sharer); err != nil {
+			return
+		}
+		if cut {
+			pkg.WriteChunk(sharer, data)
+			return
+		}
+		pkg.SendChunks(sharer, bytes.NewReader(data))
+	}()
+	defer receiver.Close()
+	client := newTestClient()
+	client.TargetDir = filepath.Dir(output)
+	return client.writeFile(receiver, bufio.NewReader(receiver), output, meta)
+}
+
+func TestWriteFileDiscardsCorruptData(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "a.txt")
+	meta := checksumMeta("world")
+	if err := sendToReceiver(t, output, meta, []byte("hello"), false); err == nil {
+		t.Fatal("writeFile accepted data with the wrong checksum")
+	}
+	for _, path := range []string{output, output + partSuffix, output + manifestSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", filepath.Base(path))
+		}
+	}
+}
+
+func TestWriteFileKeepsPartOnDisconnect(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "a.txt")
+	meta := checksumMeta("hello world")
+	if err := sendToReceiver(t, output, meta, []byte("hello"), true); !isConnError(err) {
+		t.Fatalf("writeFile returned %v, expected a connection error", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("incomplete file appeared under its final name")
+	}
+	if got, err := os.ReadFile(output + partSuffix); err != nil || string(got) != "hello" {
+		t.Errorf("part file = %q, %v; expected the data received so far", got, err)
+	}
+}
+
+func TestWriteFileKeepsForeignPart(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "x")
+	// A file of the user's that happens to use the .part name, without a
+	// manifest.
+	if err := os.WriteFile(output+partSuffix, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendToReceiver(t, output, checksumMeta("hello"), []byte("hello"), false); err != nil {
+		t.Fatalf("writeFile failed: %v", err)
+	}
+	if got, err := os.ReadFile(output); err != nil || string(got) != "hello" {
+		t.Errorf("received file = %q, %v", got, err)
+	}
+	if got, err := os.ReadFile(output + partSuffix); err != nil || string(got) != "mine" {
+		t.Errorf("existing x.part = %q, %v; expected it untouched", got, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("target holds %d entries, expected x and x.part", len(entries))
+	}
+}
+
+func TestWriteFileCountsCorruptChunk(t *testing.T) {
+	var frame bytes.Buffer
+	if err := pkg.WriteChunk(&frame, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := frame.Bytes()
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	receiver, sharer := net.Pipe()
+	go func() {
+		defer sharer.Close()
+		if _, err := pkg.ReadAck(sharer); err == nil {
+			sharer.Write(corrupt)
+		}
+	}()
+	defer receiver.Close()
+	client := newTestClient()
+	client.TargetDir = t.TempDir()
+	before := checksumFailuresTotal.Value()
+	err := client.writeFile(receiver, bufio.NewReader(receiver), filepath.Join(client.TargetDir, "a.txt"), checksumMeta("hello"))
+	if !errors.Is(err, pkg.ErrChunkChecksum) {
+		t.Errorf("writeFile returned %v, expected ErrChunkChecksum", err)
+	}
+	if got := checksumFailuresTotal.Value() - before; got != 1 {
+		t.Errorf("checksum failures grew by %v, expected 1", got)
+	}
+}
+
+func TestTransferPreservesAttributes(t *testing.T) {
+	src, outside := t.TempDir(), t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, mode := range map[string]os.FileMode{"run.sh": 0755, "private/key": 0600, "plain.txt": 0644} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "private"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(src, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("run.sh", filepath.Join(src, "latest")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(src, "private", "key"), filepath.Join(src, "abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(src, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startSharer(t, src)
+
+	for _, noPreserve := range []bool{false, true} {
+		client := newTestClient()
+		client.TargetDir = t.TempDir()
+		client.NoPreserve = noPreserve
+		if err := client.RequestData(addr); err != nil {
+			t.Fatalf("RequestData failed: %v", err)
+		}
+		target := client.TargetDir
+
+		if info, err := os.Stat(filepath.Join(target, "empty")); err != nil || !info.IsDir() {
+			t.Errorf("empty directory was not received: %v", err)
+		}
+		if link, err := os.Readlink(filepath.Join(target, "latest")); err != nil || link != "run.sh" {
+			t.Errorf("latest = %q, %v; expected a link to run.sh", link, err)
+		}
+		if link, err := os.Readlink(filepath.Join(target, "abs")); err != nil || link != filepath.Join("private", "key") {
+			t.Errorf("abs = %q, %v; expected a relative link to private/key", link, err)
+		}
+		if _, err := os.Lstat(filepath.Join(target, "escape")); !os.IsNotExist(err) {
+			t.Errorf("a symlink leading outside the share was sent: %v", err)
+		}
+
+		for name, mode := range map[string]os.FileMode{"run.sh": 0755, "private/key": 0600, "private": 0700} {
+			info, err := os.Stat(filepath.Join(target, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); !noPreserve && got != mode {
+				t.Errorf("%s has mode %v, expected %v", name, got, mode)
+			}
+			if !info.IsDir() && info.ModTime().Equal(mtime) == noPreserve {
+				t.Errorf("NoPreserve=%t: %s was modified at %v", noPreserve, name, info.ModTime())
+			}
+		}
+		// Files are created without the executable bit whatever the umask.
+		if info, _ := os.Stat(filepath.Join(target, "run.sh")); noPreserve && info.Mode().Perm()&0111 != 0 {
+			t.Errorf("NoPreserve: run.sh has mode %v", info.Mode())
+		}
+	}
+}
+
+// startRendezvous runs a rendezvous server on a loopback listener until the
+// test ends and returns its address.
+func startRendezvous(t *testing.T) string {
+	t.Helper()
+	server := rendezvous.NewServer(rendezvous.NewPeers(rendezvous.NewMemoryStore()))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	return listener.Addr().String()
+}
+
+func TestDeclineKeepsOneTimeShare(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "once.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	serverAddr := startRendezvous(t)
+	flags := []*string{new(string), new(string), new(string), &src}
+	sharer := NewSharerTCPServer("127.0.0.1:0", serverAddr, 5*time.Second, flags)
+	sharer.MaxUses = 1
+	if err := sharer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sharer.Stop() })
+	code, err := sharer.SendCodeToServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(confirm bool) error {
+		client := NewTCPClient(serverAddr, 5*time.Second)
+		client.TargetDir = t.TempDir()
+		client.Confirm = func(*config.Manifest) bool { return confirm }
+		defer client.Close()
+		addr, err := client.ReceiveCode(code)
+		if err != nil {
+			return err
+		}
+		return client.RequestData(addr)
+	}
+
+	if err := receive(false); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("declining returned %v", err)
+	}
+	if err := receive(true); err != nil {
+		t.Fatalf("receiving after another receiver declined: %v", err)
+	}
+	select {
+	case <-sharer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("one-time share did not close after its download")
+	}
+	if err := receive(true); !errors.Is(err, ErrCodeNotFound) {
+		t.Errorf("receiving a used one-time share returned %v", err)
+	}
+}
+
+func TestReceiveFolderNeedsEnd(t *testing.T) {
+	for _, end := range []bool{false, true} {
+		client := newTestClient()
+		client.TargetDir = t.TempDir()
+		receiver, sharer := net.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			done <- client.receiveFolder(receiver, bufio.NewReader(receiver))
+			receiver.Close()
+		}()
+
+		pkg.SendMetadata(sharer, config.Meta{Type: config.DIR, Path: "."})
+		if err := pkg.WaitAck(sharer); err != nil {
+			t.Fatal(err)
+		}
+		if end {
+			pkg.SendMetadata(sharer, config.Meta{Type: config.END})
+			if err := pkg.WaitAck(sharer); err != nil {
+				t.Errorf("end of folder was not acked: %v", err)
+			}
+		}
+		sharer.Close()
+
+		err := <-done
+		if end && err != nil {
+			t.Errorf("receiving a complete folder returned %v", err)
+		}
+		if !end && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("folder cut before its end returned %v, expected io.ErrUnexpectedEOF", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package qr
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, the worked example from the QR specification
+	// tutorials.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder = %v, want %v", got, want)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	// Byte mode capacity at level M for versions 1 to 10.
+	want := []int{14, 26, 42, 62, 84, 106, 122, 152, 180, 213}
+	for v := 1; v <= maxVersion; v++ {
+		got := (8*numDataCodewords(v) - 4 - charCountBits(v)) / 8
+		if got != want[v-1] {
+			t.Errorf("version %d holds %d bytes, want %d", v, got, want[v-1])
+		}
+	}
+}
+
+// readFormat reads the first copy of the format bits back from the symbol.
+func readFormat(c *Code) int {
+	bits := 0
+	set := func(i int, dark bool) {
+		if dark {
+			bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		set(i, c.Modules[i][8])
+	}
+	set(6, c.Modules[7][8])
+	set(7, c.Modules[8][8])
+	set(8, c.Modules[8][7])
+	for i := 9; i < 15; i++ {
+		set(i, c.Modules[8][14-i])
+	}
+	return bits
+}
+
+func TestFormatBits(t *testing.T) {
+	// Level M format strings for masks 0 to 7.
+	want := []int{
+		0b101010000010010, 0b101000100100101, 0b101111001111100, 0b101101101001011,
+		0b100010111111001, 0b100000011001110, 0b100111110010111, 0b100101010100000,
+	}
+	c := newCode(1)
+	for mask, w := range want {
+		c.drawFormatBits(mask)
+		if got := readFormat(c); got != w {
+			t.Errorf("mask %d: format bits %015b, want %015b", mask, got, w)
+		}
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	c := newCode(7)
+	c.drawVersion()
+	// Version 7 information is 000111110010010100, read from the bottom left
+	// block column by column.
+	want := 0b000111110010010100
+	got := 0
+	for i := 0; i < 18; i++ {
+		if c.Modules[c.Size-11+i%3][i/3] {
+			got |= 1 << i
+		}
+	}
+	if got != want {
+		t.Errorf("version bits %018b, want %018b", got, want)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, text := range []string{"7-apple-river", "x7Kp2Q-T4mz9WbR1c", string(bytes.Repeat([]byte("abc-"), 40))} {
+		c, err := Encode(text)
+		if err != nil {
+			t.Fatalf("Encode(%q) failed: %v", text, err)
+		}
+
+		// Undo the mask named by the format bits and read the codewords back
+		// in placement order.
+		mask := (readFormat(c) ^ 0x5412) >> 10 & 7
+		c.applyMask(mask)
+		var bits bitBuffer
+		for right := c.Size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := 0; vert < c.Size; vert++ {
+				for j := 0; j < 2; j++ {
+					x, y := right-j, vert
+					if (right+1)&2 == 0 {
+						y = c.Size - 1 - vert
+					}
+					if !c.isFunction[y][x] {
+						bits = append(bits, c.Modules[y][x])
+					}
+				}
+			}
+		}
+		got := bits.bytes()[:numRawDataModules(c.Version)/8]
+
+		// With a single block the data codewords come first, followed by
+		// the error correction.
+		if numBlocks[c.Version] == 1 {
+			n := numDataCodewords(c.Version)
+			if got[0]>>4 != 0x4 {
+				t.Errorf("%q: expected byte mode indicator, got %x", text, got[0]>>4)
+			}
+			if ecc := rsRemainder(got[:n], rsDivisor(eccPerBlock[c.Version])); !bytes.Equal(ecc, got[n:]) {
+				t.Errorf("%q: error correction does not match data", text)
+			}
+		}
+		if want := addECCAndInterleave(dataCodewords([]byte(text), c.Version), c.Version); !bytes.Equal(got, want) {
+			t.Errorf("%q: codewords read back differ from the ones encoded", text)
+		}
+	}
+}
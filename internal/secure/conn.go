@@ -0,0 +1,111 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxRecord is the largest plaintext sealed into a single record.
+const maxRecord = 64 * 1024
+
+// Conn is a net.Conn whose traffic is sealed with AES-256-GCM. Every record is
+// a 4-byte big-endian ciphertext length followed by the ciphertext; nonces are
+// per-direction sequence numbers so records cannot be replayed or reordered.
+type Conn struct {
+	net.Conn
+	send    cipher.AEAD
+	recv    cipher.AEAD
+	sendSeq uint64
+	recvSeq uint64
+	pending []byte // decrypted bytes not yet returned by Read
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+}
+
+func newConn(conn net.Conn, sendKey, recvKey []byte) (*Conn, error) {
+	send, err := newAEAD(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := newAEAD(recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, send: send, recv: recv}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+// Write seals b into one or more records and writes them to the connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), maxRecord)
+		record := make([]byte, 4, 4+n+c.send.Overhead())
+		record = c.send.Seal(record, nonce(c.send, c.sendSeq), b[:n], nil)
+		binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+		if _, err := c.Conn.Write(record); err != nil {
+			return written, err
+		}
+		c.sendSeq++
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Read returns decrypted data, reading and opening a new record when the
+// previous one has been fully consumed.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.pending) == 0 {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > maxRecord+uint32(c.recv.Overhead()) {
+			return 0, fmt.Errorf("encrypted record too large: %d bytes", size)
+		}
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		plain, err := c.recv.Open(sealed[:0], nonce(c.recv, c.recvSeq), sealed, nil)
+		if err != nil {
+			return 0, errors.New("failed to decrypt record: data was tampered with")
+		}
+		c.recvSeq++
+		c.pending = plain
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
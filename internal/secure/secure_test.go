@@ -0,0 +1,118 @@
+package secure
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"filippo.io/edwards25519"
+)
+
+func handshakePair(t *testing.T, receiverCode, sharerCode string) (*Conn, *Conn, error, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		conn, err := Handshake(b, sharerCode, false)
+		if err != nil {
+			b.Close()
+		}
+		done <- result{conn, err}
+	}()
+	initiator, err := Handshake(a, receiverCode, true)
+	if err != nil {
+		a.Close()
+	}
+	responder := <-done
+	return initiator, responder.conn, err, responder.err
+}
+
+func TestHandshakeAndRecords(t *testing.T) {
+	receiver, sharer, errA, errB := handshakePair(t, "apple-river", "apple-river")
+	if errA != nil || errB != nil {
+		t.Fatalf("handshake failed: %v / %v", errA, errB)
+	}
+
+	msg := make([]byte, 3*maxRecord+10)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	go func() {
+		sharer.Write(msg)
+	}()
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(receiver, got); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	for i := range got {
+		if got[i] != msg[i] {
+			t.Fatalf("byte %d differs", i)
+		}
+	}
+}
+
+func TestHandshakeWrongCode(t *testing.T) {
+	_, _, errA, errB := handshakePair(t, "apple-river", "apple-rivet")
+	if !errors.Is(errA, ErrWrongCode) {
+		t.Errorf("initiator: expected ErrWrongCode, got %v", errA)
+	}
+	if !errors.Is(errB, ErrWrongCode) {
+		t.Errorf("responder: expected ErrWrongCode, got %v", errB)
+	}
+}
+
+func TestTamperedRecord(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	key := make([]byte, 32)
+	sender, _ := newConn(a, key, key)
+	receiver, _ := newConn(b, key, key)
+
+	go func() {
+		record := make([]byte, 4, 64)
+		record = sender.send.Seal(record, nonce(sender.send, 0), []byte("hello"), nil)
+		record[len(record)-1] ^= 1
+		record[3] = byte(len(record) - 4)
+		a.Write(record)
+	}()
+	if _, err := receiver.Read(make([]byte, 16)); err == nil {
+		t.Fatal("expected tampered record to be rejected")
+	}
+}
+
+func TestBlindingPoints(t *testing.T) {
+	// Clearing the cofactor and undoing it again only gives back a point
+	// without a small order component.
+	eight, _ := edwards25519.NewScalar().SetCanonicalBytes(append([]byte{8}, make([]byte, 31)...))
+	inverse := edwards25519.NewScalar().Invert(eight)
+	for name, p := range map[string]*edwards25519.Point{"M": groupM, "N": groupN} {
+		q := new(edwards25519.Point).MultByCofactor(p)
+		if q.Equal(edwards25519.NewIdentityPoint()) == 1 || q.ScalarMult(inverse, q).Equal(p) != 1 {
+			t.Errorf("%s is not in the prime order subgroup", name)
+		}
+	}
+}
+
+func TestHandshakeRejectsDegenerateShare(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	// A share that unblinds to the identity would fix the key whatever the
+	// initiator picked.
+	go func() {
+		io.ReadFull(b, make([]byte, elementSize))
+		b.Write(new(edwards25519.Point).ScalarMult(passwordScalar("apple-river"), groupN).Bytes())
+	}()
+	if _, err := Handshake(a, "apple-river", true); err == nil || errors.Is(err, ErrWrongCode) {
+		t.Errorf("expected an invalid key share error, got %v", err)
+	}
+}
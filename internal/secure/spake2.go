@@ -0,0 +1,201 @@
+// Package secure implements the end-to-end encrypted channel between a sharer
+// and a receiver. Both peers prove knowledge of the secret part of the share
+// code with a SPAKE2 password-authenticated key exchange and then exchange all
+// traffic as AES-GCM sealed records. The rendezvous server never sees the
+// secret, so neither it nor anyone on the path can read or tamper with the
+// transfer, and an active attacker gets a single online guess per handshake.
+package secure
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"filippo.io/edwards25519"
+)
+
+// ErrWrongCode is returned when the peer did not derive the same key, which
+// almost always means the two sides used different share codes.
+var ErrWrongCode = errors.New("key confirmation failed: the share code does not match")
+
+const (
+	protocolLabel = "DirectDrop SPAKE2 v2"
+	elementSize   = 32 // bytes of an encoded group element
+)
+
+// The exchange runs in the prime order group of edwards25519, whose
+// arithmetic is constant time, so the password scalar does not leak through
+// timing. M and N blind the initiator's and responder's messages; they are
+// the points of RFC 9382, hashed from seeds so nobody knows their discrete
+// logarithms.
+var (
+	groupM = mustPoint("d048032c6ea0b6d697ddc2e86bda85a33adac920f1bf18e1b0c6d166a5cecdaf")
+	groupN = mustPoint("d3bfb518f44f3430f29d0c92af503865a1ed3281dc69b35dd868ba85f886c4ab")
+)
+
+func mustPoint(s string) *edwards25519.Point {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("secure: invalid group constant")
+	}
+	p, err := new(edwards25519.Point).SetBytes(b)
+	if err != nil {
+		panic("secure: invalid group constant")
+	}
+	return p
+}
+
+// expand deterministically stretches label and data into n pseudo-random
+// bytes using SHA256 in counter mode.
+func expand(label string, data []byte, n int) []byte {
+	out := make([]byte, 0, n+sha256.Size)
+	for counter := uint32(0); len(out) < n; counter++ {
+		h := sha256.New()
+		h.Write([]byte(protocolLabel))
+		h.Write([]byte(label))
+		binary.Write(h, binary.BigEndian, counter)
+		h.Write(data)
+		out = h.Sum(out)
+	}
+	return out[:n]
+}
+
+// passwordScalar derives the SPAKE2 password scalar w from the shared secret.
+func passwordScalar(password string) *edwards25519.Scalar {
+	w, err := edwards25519.NewScalar().SetUniformBytes(expand("password", []byte(password), 64))
+	if err != nil {
+		panic(err) // 64 bytes are always accepted
+	}
+	return w
+}
+
+// Handshake runs SPAKE2 over conn using password and returns a Conn that
+// encrypts everything written to and read from it. The receiver of a share is
+// the initiator, the sharer the responder. Callers should set a deadline on
+// conn since the handshake blocks on the peer.
+func Handshake(conn net.Conn, password string, initiator bool) (*Conn, error) {
+	if password == "" {
+		return nil, errors.New("empty password")
+	}
+	w := passwordScalar(password)
+
+	blind, peerBlind := groupM, groupN
+	if !initiator {
+		blind, peerBlind = groupN, groupM
+	}
+
+	random := make([]byte, 64)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	x, err := edwards25519.NewScalar().SetUniformBytes(random)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	// msg = x*G + w*blind
+	msg := new(edwards25519.Point).ScalarBaseMult(x)
+	msg.Add(msg, new(edwards25519.Point).ScalarMult(w, blind))
+	ours := msg.Bytes()
+
+	theirs := make([]byte, elementSize)
+	if initiator {
+		if _, err := conn.Write(ours); err != nil {
+			return nil, fmt.Errorf("failed to send key share: %w", err)
+		}
+		if _, err := io.ReadFull(conn, theirs); err != nil {
+			return nil, fmt.Errorf("failed to read key share: %w", err)
+		}
+	} else {
+		if _, err := io.ReadFull(conn, theirs); err != nil {
+			return nil, fmt.Errorf("failed to read key share: %w", err)
+		}
+		if _, err := conn.Write(ours); err != nil {
+			return nil, fmt.Errorf("failed to send key share: %w", err)
+		}
+	}
+
+	peer, err := new(edwards25519.Point).SetBytes(theirs)
+	if err != nil {
+		return nil, errors.New("invalid key share from peer")
+	}
+
+	// shared = 8*x*(peer - w*peerBlind). Multiplying by the cofactor drops
+	// any small order component the peer mixed in.
+	shared := new(edwards25519.Point).ScalarMult(w, peerBlind)
+	shared.Subtract(peer, shared)
+	shared.MultByCofactor(shared)
+	shared.ScalarMult(x, shared)
+	if shared.Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, errors.New("invalid key share from peer")
+	}
+
+	// The transcript binds the keys to both messages and the password.
+	msgA, msgB := ours, theirs
+	if !initiator {
+		msgA, msgB = theirs, ours
+	}
+	transcript := sha256.New()
+	transcript.Write([]byte(protocolLabel))
+	transcript.Write(msgA)
+	transcript.Write(msgB)
+	transcript.Write(shared.Bytes())
+	transcript.Write(w.Bytes())
+	th := transcript.Sum(nil)
+
+	keys, err := hkdf.Key(sha256.New, th, nil, protocolLabel+" keys", 4*32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive keys: %w", err)
+	}
+	confirmA, confirmB, keyAB, keyBA := keys[0:32], keys[32:64], keys[64:96], keys[96:128]
+
+	macA := confirmationMAC(confirmA, th)
+	macB := confirmationMAC(confirmB, th)
+	if initiator {
+		if _, err := conn.Write(macA); err != nil {
+			return nil, fmt.Errorf("failed to send key confirmation: %w", err)
+		}
+		if err := readConfirmation(conn, macB); err != nil {
+			return nil, err
+		}
+		return newConn(conn, keyAB, keyBA)
+	}
+
+	// The responder only reveals its confirmation once the initiator has
+	// proven it knows the password.
+	if err := readConfirmation(conn, macA); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(macB); err != nil {
+		return nil, fmt.Errorf("failed to send key confirmation: %w", err)
+	}
+	return newConn(conn, keyBA, keyAB)
+}
+
+func confirmationMAC(key, transcript []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+func readConfirmation(conn net.Conn, expected []byte) error {
+	got := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// The peer hangs up when our confirmation did not match theirs.
+			return ErrWrongCode
+		}
+		return fmt.Errorf("failed to read key confirmation: %w", err)
+	}
+	if !hmac.Equal(got, expected) {
+		return ErrWrongCode
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CHUNK_SIZE is the amount of raw file data carried by a single chunk.
+const CHUNK_SIZE = 1 << 20
+
+// File contents travel as a sequence of framed chunks so neither side ever
+// holds more than one chunk in memory. Each frame is laid out as:
+//
+//	[4 bytes]  big-endian length of the compressed payload
+//	[64 bytes] hex encoded SHA256 of the compressed payload
+//	[n bytes]  gzip compressed payload
+//
+// A frame with a zero length and no checksum marks the end of the file.
+const (
+	chunkLenSize    = 4
+	chunkSumSize    = 64
+	chunkHeaderSize = chunkLenSize + chunkSumSize
+	// maxChunkPayload bounds the compressed size we are willing to read so a
+	// corrupt or hostile length cannot make us allocate arbitrary memory.
+	maxChunkPayload = 2 * CHUNK_SIZE
+)
+
+// WriteChunk compresses data and writes it to w as a single frame.
+func WriteChunk(w io.Writer, data []byte) error {
+	compressed, checksum, err := CompressData(data)
+	if err != nil {
+		return fmt.Errorf("failed to compress chunk: %w", err)
+	}
+
+	header := make([]byte, chunkHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(compressed)))
+	copy(header[chunkLenSize:], checksum)
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write chunk header: %w", err)
+	}
+	if _, err := w.Write(compressed); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	return nil
+}
+
+// WriteEndChunk writes the frame that terminates a file.
+func WriteEndChunk(w io.Writer) error {
+	if _, err := w.Write(make([]byte, chunkHeaderSize)); err != nil {
+		return fmt.Errorf("failed to write end chunk: %w", err)
+	}
+	return nil
+}
+
+// ErrChunkChecksum is returned by ReadChunk when a frame does not match its
+// checksum.
+var ErrChunkChecksum = errors.New("chunk checksum error")
+
+// ReadChunk reads a single frame from r, verifies its checksum and returns the
+// decompressed data. It returns io.EOF once the end frame is read.
+func ReadChunk(r io.Reader) ([]byte, error) {
+	header := make([]byte, chunkHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read chunk header: %w", err)
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size == 0 {
+		return nil, io.EOF
+	}
+	if size > maxChunkPayload {
+		return nil, fmt.Errorf("chunk too large: %d bytes", size)
+	}
+
+	compressed := make([]byte, size)
+	if _, err := io.ReadFull(r, compressed); err != nil {
+		return nil, fmt.Errorf("failed to read chunk: %w", err)
+	}
+	if err := VerifyChecksum(compressed, string(header[chunkLenSize:])); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChunkChecksum, err)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+	}
+	defer gr.Close()
+
+	data, err := io.ReadAll(io.LimitReader(gr, CHUNK_SIZE+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk: %w", err)
+	}
+	if len(data) > CHUNK_SIZE {
+		return nil, errors.New("chunk exceeds maximum size once decompressed")
+	}
+	return data, nil
+}
+
+// SendChunks streams everything from r to w as chunks followed by the end
+// frame and returns the number of raw bytes sent.
+func SendChunks(w io.Writer, r io.Reader) (int64, error) {
+	buf := make([]byte, CHUNK_SIZE)
+	var total int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := WriteChunk(w, buf[:n]); err != nil {
+				return total, err
+			}
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return total, fmt.Errorf("failed to read data: %w", err)
+		}
+	}
+	return total, WriteEndChunk(w)
+}
+
+// ReceiveChunks reads chunks from r until the end frame, writing the
+// decompressed data to w, and returns the number of raw bytes received.
+func ReceiveChunks(w io.Writer, r io.Reader) (int64, error) {
+	var total int64
+	for {
+		data, err := ReadChunk(r)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return total, fmt.Errorf("failed to write data: %w", err)
+		}
+		total += int64(len(data))
+	}
+}
@@ -0,0 +1,64 @@
+package rendezvous
+
+// Hooks are called by a Server as it answers requests and registrations
+// change, e.g. to collect metrics or keep an audit log. Nil hooks are
+// skipped. They run on the goroutine serving the client before its response
+// is sent, so they must be safe for concurrent use and return quickly.
+type Hooks struct {
+	OnRequest  func(typ string)                               // a request was received
+	OnError    func(err *Error)                               // an ERROR response was sent
+	OnRegister func(code string, peer PeerInfo, updated bool) // an ADD was accepted
+	OnLookup   func(code string, found bool)                  // a LOOK was answered
+	OnDelete   func(code string)                              // the owner removed a registration
+	OnCollect  func(code string)                              // a stale or expired registration was dropped
+	OnBan      func(ip string)                                // a client was banned for failed lookups
+	OnRelay    func(code string, up, down int64)              // a relayed session ended
+}
+
+func (h *Hooks) request(typ string) {
+	if h.OnRequest != nil {
+		h.OnRequest(typ)
+	}
+}
+
+func (h *Hooks) error(err *Error) {
+	if h.OnError != nil {
+		h.OnError(err)
+	}
+}
+
+func (h *Hooks) register(code string, peer PeerInfo, updated bool) {
+	if h.OnRegister != nil {
+		h.OnRegister(code, peer, updated)
+	}
+}
+
+func (h *Hooks) lookup(code string, found bool) {
+	if h.OnLookup != nil {
+		h.OnLookup(code, found)
+	}
+}
+
+func (h *Hooks) delete(code string) {
+	if h.OnDelete != nil {
+		h.OnDelete(code)
+	}
+}
+
+func (h *Hooks) collect(code string) {
+	if h.OnCollect != nil {
+		h.OnCollect(code)
+	}
+}
+
+func (h *Hooks) ban(ip string) {
+	if h.OnBan != nil {
+		h.OnBan(ip)
+	}
+}
+
+func (h *Hooks) relay(code string, up, down int64) {
+	if h.OnRelay != nil {
+		h.OnRelay(code, up, down)
+	}
+}
@@ -0,0 +1,284 @@
+package rendezvous
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Limits configures the abuse protection of the server. A zero field disables
+// that limit.
+type Limits struct {
+	MaxConns      int           // open connections in total
+	MaxConnsPerIP int           // open connections from one IP
+	MaxCodesPerIP int           // registrations held by one IP
+	Rate          float64       // requests per second from one IP
+	ConnRate      float64       // requests per second on one connection
+	MissLimit     int           // LOOK misses from one IP before lookups back off
+	BanAfter      int           // LOOK misses from one IP before it is banned
+	BanDuration   time.Duration // how long a ban lasts
+	MissWindow    time.Duration // misses are forgotten after this long without one
+}
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Limiter enforces Limits per client IP. Rates allow bursts of up to twice
+// the rate per second. A nil *Limiter imposes no limits.
+type Limiter struct {
+	limits Limits
+
+	mu        sync.Mutex
+	conns     int
+	clients   map[string]*clientState
+	codes     map[string]string // IP that registered each code
+	nextSweep time.Time
+}
+
+// clientState tracks one IP.
+type clientState struct {
+	conns       int
+	codes       int // registrations held
+	requests    bucket
+	misses      int
+	lastMiss    time.Time
+	retryAt     time.Time // no lookups before this while backing off
+	bannedUntil time.Time
+}
+
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{limits: limits, clients: make(map[string]*clientState), codes: make(map[string]string)}
+}
+
+// Open admits a new connection from ip, or returns why it is refused.
+// Admitted connections must be released with Close.
+func (l *Limiter) Open(ip string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	c := l.client(ip)
+	if now.Before(c.bannedUntil) {
+		return tooManyRequests(time.Until(c.bannedUntil), "banned after too many failed lookups")
+	}
+	if l.limits.MaxConns > 0 && l.conns >= l.limits.MaxConns {
+		return Errorf(TooManyRequests, "server is at its connection limit")
+	}
+	if l.limits.MaxConnsPerIP > 0 && c.conns >= l.limits.MaxConnsPerIP {
+		return Errorf(TooManyRequests, "too many connections from %s", ip)
+	}
+	l.conns++
+	c.conns++
+	return nil
+}
+
+// Close releases a connection admitted by Open.
+func (l *Limiter) Close(ip string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns--
+	l.client(ip).conns--
+}
+
+// Allow checks a request from ip against the per-IP rate and bans.
+func (l *Limiter) Allow(ip string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c := l.client(ip)
+	if now.Before(c.bannedUntil) {
+		return tooManyRequests(c.bannedUntil.Sub(now), "banned after too many failed lookups")
+	}
+	if !c.requests.allow(now, l.limits.Rate) {
+		return tooManyRequests(time.Second, "rate limit exceeded")
+	}
+	return nil
+}
+
+// AllowConn checks a request against the per-connection rate, using the
+// connection's own bucket.
+func (l *Limiter) AllowConn(b *bucket) error {
+	if l == nil {
+		return nil
+	}
+	if !b.allow(time.Now(), l.limits.ConnRate) {
+		return tooManyRequests(time.Second, "rate limit exceeded on this connection")
+	}
+	return nil
+}
+
+// AllowLook checks whether ip may look up a code, which it may not while it
+// backs off after repeated misses.
+func (l *Limiter) AllowLook(ip string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if wait := time.Until(l.client(ip).retryAt); wait > 0 {
+		return tooManyRequests(wait, "too many failed lookups")
+	}
+	return nil
+}
+
+// Miss records a LOOK from ip for a code that was not found. After MissLimit
+// misses further lookups must wait, twice as long after every miss, and after
+// BanAfter misses the IP is banned for BanDuration. It reports whether the
+// IP was banned.
+func (l *Limiter) Miss(ip string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c := l.client(ip)
+	if l.limits.MissWindow > 0 && now.Sub(c.lastMiss) > l.limits.MissWindow {
+		c.misses = 0
+	}
+	c.misses++
+	c.lastMiss = now
+
+	if l.limits.BanAfter > 0 && c.misses >= l.limits.BanAfter {
+		c.bannedUntil = now.Add(l.limits.BanDuration)
+		c.misses = 0
+		c.retryAt = time.Time{}
+		slog.Warn("Banned client", "ip", ip, "until", c.bannedUntil)
+		return true
+	}
+	if l.limits.MissLimit > 0 && c.misses >= l.limits.MissLimit {
+		backoff := min(minBackoff<<min(c.misses-l.limits.MissLimit, 16), maxBackoff)
+		c.retryAt = now.Add(backoff)
+		slog.Debug("Backing off lookups", "ip", ip, "misses", c.misses, "backoff", backoff)
+	}
+	return false
+}
+
+// Register counts the registration of code by ip, or returns why ip may not
+// hold another one. A code that is already counted is left alone, so updates
+// are free. The registration is released by Unregister once the code is
+// deleted or collected.
+func (l *Limiter) Register(ip, code string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.codes[code]; ok {
+		return nil
+	}
+	c := l.client(ip)
+	if l.limits.MaxCodesPerIP > 0 && c.codes >= l.limits.MaxCodesPerIP {
+		return Errorf(TooManyRequests, "too many codes registered from %s", ip)
+	}
+	c.codes++
+	l.codes[code] = ip
+	return nil
+}
+
+// Unregister releases the registration of code counted by Register.
+func (l *Limiter) Unregister(code string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ip, ok := l.codes[code]
+	if !ok {
+		return
+	}
+	delete(l.codes, code)
+	l.client(ip).codes--
+}
+
+// Banned reports whether ip is currently banned.
+func (l *Limiter) Banned(ip string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.client(ip).bannedUntil)
+}
+
+// client returns the state for ip, creating it if needed. l.mu must be held.
+func (l *Limiter) client(ip string) *clientState {
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &clientState{}
+		l.clients[ip] = c
+	}
+	return c
+}
+
+// sweep forgets IPs with nothing worth remembering, at most once a minute.
+// l.mu must be held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(time.Minute)
+	for ip, c := range l.clients {
+		idle := c.conns == 0 && c.codes == 0 && now.After(c.bannedUntil) && now.After(c.retryAt) && now.Sub(c.requests.last) > time.Minute
+		if idle && (l.limits.MissWindow == 0 || now.Sub(c.lastMiss) > l.limits.MissWindow) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// bucket is a token bucket refilled at a given rate and holding up to twice
+// the rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is available. A rate of 0 allows everything.
+func (b *bucket) allow(now time.Time, rate float64) bool {
+	if rate <= 0 {
+		return true
+	}
+	burst := max(1, 2*rate)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func tooManyRequests(retry time.Duration, reason string) *Error {
+	return Errorf(TooManyRequests, "%s, retry in %s", reason, retry.Round(time.Second))
+}
+
+// clientIP returns the IP of the client at the other end of conn.
+func clientIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,153 @@
+package rendezvous
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionLimits(t *testing.T) {
+	addr := startLimitedServer(t, newPeers(), nil, NewLimiter(Limits{MaxConnsPerIP: 1}))
+
+	first := dialServer(t, addr)
+	if got := errorCode(dialRaw(t, addr).read()); got != TooManyRequests {
+		t.Errorf("second connection from the same IP: got error %d", got)
+	}
+
+	first.conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c := dialRaw(t, addr)
+		resp := c.send(Request{Type: TypeHello, Version: Version})
+		if resp.Type == TypeHello {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection still refused after the first one closed: %+v", resp)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	addr := startLimitedServer(t, newPeers(), nil, NewLimiter(Limits{ConnRate: 1}))
+	client := dialServer(t, addr) // HELLO takes the first of two tokens
+
+	if got := errorCode(client.send(Request{Type: "LOOK", Code: "7"})); got != NotFound {
+		t.Errorf("request within the burst: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "LOOK", Code: "7"})); got != TooManyRequests {
+		t.Errorf("request over the rate: got error %d", got)
+	}
+	// Another connection has its own allowance.
+	if got := errorCode(dialServer(t, addr).send(Request{Type: "LOOK", Code: "7"})); got != NotFound {
+		t.Errorf("request on a new connection: got error %d", got)
+	}
+}
+
+func TestLookupBackoffAndBan(t *testing.T) {
+	limiter := NewLimiter(Limits{MissLimit: 2, BanAfter: 4, BanDuration: time.Minute})
+	peers := newPeers()
+	addr := startLimitedServer(t, peers, nil, limiter)
+	client := dialServer(t, addr)
+	client.add("7", "10.0.0.1:9000")
+
+	look := func(code string) int {
+		t.Helper()
+		return errorCode(client.send(Request{Type: "LOOK", Code: code}))
+	}
+	skipBackoff := func() {
+		limiter.mu.Lock()
+		limiter.clients["127.0.0.1"].retryAt = time.Time{}
+		limiter.mu.Unlock()
+	}
+
+	look("8")
+	look("9")
+	if got := look("7"); got != TooManyRequests {
+		t.Errorf("lookup while backing off: got error %d", got)
+	}
+	skipBackoff()
+	if got := look("7"); got != 0 {
+		t.Errorf("lookup after the back-off: got error %d", got)
+	}
+
+	look("10")
+	skipBackoff()
+	if got := look("11"); got != NotFound {
+		t.Errorf("miss that triggers the ban: got error %d", got)
+	}
+	if got := look("7"); got != TooManyRequests {
+		t.Errorf("lookup while banned: got error %d", got)
+	}
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Errorf("banned client's connection was not closed")
+	}
+	if got := errorCode(dialRaw(t, addr).read()); got != TooManyRequests {
+		t.Errorf("new connection while banned: got error %d", got)
+	}
+}
+
+func TestRelayMissesCount(t *testing.T) {
+	limiter := NewLimiter(Limits{MissLimit: 2, BanAfter: 4, BanDuration: time.Minute})
+	addr := startLimitedServer(t, newPeers(), NewRelay(0, time.Minute, time.Minute), limiter)
+	client := dialServer(t, addr)
+	client.add("7", "10.0.0.1:9000")
+
+	// Guessing codes over RELAY backs off like guessing them over LOOK.
+	for _, code := range []string{"8", "9"} {
+		if got := errorCode(client.send(Request{Type: TypeRelay, Code: code})); got != NotFound {
+			t.Errorf("RELAY to unknown code %s: got error %d", code, got)
+		}
+	}
+	if got := errorCode(client.send(Request{Type: TypeRelay, Code: "7"})); got != TooManyRequests {
+		t.Errorf("RELAY while backing off: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "LOOK", Code: "7"})); got != TooManyRequests {
+		t.Errorf("LOOK while backing off from RELAY misses: got error %d", got)
+	}
+}
+
+func TestCodesPerIP(t *testing.T) {
+	peers := newPeers()
+	addr := startLimitedServer(t, peers, nil, NewLimiter(Limits{MaxCodesPerIP: 2}))
+	client := dialServer(t, addr)
+
+	token := client.add("7", "10.0.0.1:9000")
+	client.add("8", "10.0.0.1:9000")
+	if got := errorCode(client.send(Request{Type: "ADD", Code: "9", Address: "10.0.0.1:9000"})); got != TooManyRequests {
+		t.Errorf("ADD over the limit: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "ADD", Address: "10.0.0.1:9000"})); got != TooManyRequests {
+		t.Errorf("allocation over the limit: got error %d", got)
+	}
+	if _, exist := peers.get("9"); exist {
+		t.Errorf("refused registration was kept")
+	}
+	// A token does not get around the limit either.
+	if got := errorCode(client.send(Request{Type: "ADD", Code: "9", Address: "10.0.0.1:9000", Token: "claimed"})); got != TooManyRequests {
+		t.Errorf("ADD with a made-up token over the limit: got error %d", got)
+	}
+
+	// Updates are free, and deleting a code makes room for another.
+	if resp := client.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.2:9000", Token: token}); !resp.Updated {
+		t.Errorf("update at the limit: got %+v", resp)
+	}
+	client.send(Request{Type: "DEL", Code: "7", Token: token})
+	client.add("9", "10.0.0.1:9000")
+}
+
+func TestBucket(t *testing.T) {
+	var b bucket
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		if !b.allow(now, 2) {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	if b.allow(now, 2) {
+		t.Errorf("request over the burst was allowed")
+	}
+	if !b.allow(now.Add(500*time.Millisecond), 2) {
+		t.Errorf("request after the bucket refilled was refused")
+	}
+}
@@ -0,0 +1,241 @@
+package rendezvous
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/pkg"
+)
+
+type PeerInfo struct {
+	Address   string    `json:"address"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Token     string    `json:"-"`                   // proves ownership of the registration
+	MaxUses   int       `json:"maxUses,omitempty"`   // completed downloads allowed, 0 for unlimited
+	Downloads int       `json:"downloads,omitempty"` // completed downloads reported by the owner
+	Lookups   int       `json:"lookups,omitempty"`
+	Expires   time.Time `json:"expires,omitempty"` // zero if the share does not expire
+	Created   time.Time `json:"created"`
+	Revoked   bool      `json:"revoked,omitempty"` // revoked by an operator, kept so the owner cannot register it again
+}
+
+// Available reports whether receivers may still look the peer up. Only
+// downloads the owner reported as completed use up a share, so a receiver
+// that declines or is interrupted does not. A share that is used up or
+// expired stays registered to its owner until it is deleted or collected, so
+// nobody else can take the code in the meantime.
+func (p PeerInfo) Available(now time.Time) bool {
+	if p.Revoked {
+		return false
+	}
+	if !p.Expires.IsZero() && now.After(p.Expires) {
+		return false
+	}
+	return p.MaxUses == 0 || p.Downloads < p.MaxUses
+}
+
+type Peers struct {
+	store Store
+	mu    sync.RWMutex
+}
+
+// NewPeers returns a Peers that keeps its registrations in store.
+func NewPeers(store Store) *Peers {
+	return &Peers{store: store}
+}
+
+// Add registers peer under shareCode and returns the owner token that later
+// updates must present. Without peer.Token a new registration is created and
+// an existing one is left alone, so one sharer can never replace another's
+// code; with a token an existing registration is only updated if it matches.
+func (p *Peers) Add(shareCode string, peer PeerInfo) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.add(shareCode, peer)
+}
+
+// Allocate registers peer under the lowest nameplate that is free and returns
+// it along with the owner token. Free nameplates are reused, so codes stay
+// short however many shares have come and gone.
+func (p *Peers) Allocate(peer PeerInfo) (string, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer.Token = ""
+	for n := 1; n <= pkg.MAX_NAMEPLATE; n++ {
+		code := strconv.Itoa(n)
+		if _, exist := p.store.Get(code); exist {
+			continue
+		}
+		token, err := p.add(code, peer)
+		if err != nil {
+			return "", "", err
+		}
+		return code, token, nil
+	}
+	return "", "", Errorf(CodeInUse, "no free code left")
+}
+
+// add does the work of Add. p.mu must be held.
+func (p *Peers) add(shareCode string, peer PeerInfo) (string, error) {
+	if existing, exist := p.store.Get(shareCode); exist {
+		if peer.Token == "" {
+			return "", ErrCodeInUse
+		}
+		if !validToken(existing, peer.Token) {
+			return "", ErrInvalidToken
+		}
+		if existing.Revoked {
+			return "", ErrRevoked
+		}
+		peer.Lookups = existing.Lookups
+		peer.Downloads = max(peer.Downloads, existing.Downloads)
+		peer.Created = existing.Created
+	}
+	if peer.Token == "" {
+		peer.Token = pkg.GenerateRandomString(32)
+	}
+	peer.LastSeen = time.Now()
+	if peer.Created.IsZero() {
+		peer.Created = peer.LastSeen
+	}
+	if err := p.store.Put(shareCode, peer); err != nil {
+		return "", fmt.Errorf("saving registration: %w", err)
+	}
+	return peer.Token, nil
+}
+
+// Look returns the peer registered under shareCode if it is available and
+// counts the lookup. Lookups do not use up a share; see Available.
+func (p *Peers) Look(shareCode string) (PeerInfo, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, exist := p.store.Get(shareCode)
+	if !exist || !peer.Available(time.Now()) {
+		return PeerInfo{}, false
+	}
+	peer.Lookups++
+	// The receiver is answered even if the count cannot be saved; at worst
+	// the share is handed out once more after a restart.
+	if err := p.store.Put(shareCode, peer); err != nil {
+		slog.Error("Saving lookup", "code", shareCode, "err", err)
+	}
+	return peer, true
+}
+
+// Authorize checks that token owns the registration for shareCode.
+func (p *Peers) Authorize(shareCode, token string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	peer, exist := p.store.Get(shareCode)
+	if !exist || !validToken(peer, token) {
+		return ErrInvalidToken
+	}
+	if peer.Revoked {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// Refresh marks the registration for shareCode as seen now so gc keeps it and
+// records the downloads its owner completed so far.
+func (p *Peers) Refresh(shareCode, token string, downloads int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, exist := p.store.Get(shareCode)
+	if !exist {
+		return ErrNotFound
+	}
+	if !validToken(peer, token) {
+		return ErrInvalidToken
+	}
+	if peer.Revoked {
+		return ErrRevoked
+	}
+	peer.LastSeen = time.Now()
+	peer.Downloads = max(peer.Downloads, downloads)
+	return p.store.Put(shareCode, peer)
+}
+
+// Delete removes the registration for shareCode. A revoked registration
+// stays until it is collected, so its owner cannot delete it and register the
+// code again.
+func (p *Peers) Delete(shareCode, token string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, exist := p.store.Get(shareCode)
+	if !exist {
+		return ErrNotFound
+	}
+	if !validToken(peer, token) {
+		return ErrInvalidToken
+	}
+	if peer.Revoked {
+		return ErrRevoked
+	}
+	return p.store.Delete(shareCode)
+}
+
+// Revoke withdraws the registration for shareCode without its owner token.
+// Receivers can no longer look it up and its owner is told so on the next
+// refresh; the code stays taken until the registration is collected.
+func (p *Peers) Revoke(shareCode string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peer, exist := p.store.Get(shareCode)
+	if !exist {
+		return ErrNotFound
+	}
+	peer.Revoked = true
+	return p.store.Put(shareCode, peer)
+}
+
+// Range calls fn for each registration until fn returns false. The
+// registrations cannot change while Range runs.
+func (p *Peers) Range(fn func(code string, peer PeerInfo) bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.store.Range(fn)
+}
+
+// Collect removes the registrations not seen within ttl and those that have
+// expired, and returns their codes.
+func (p *Peers) Collect(ttl time.Duration) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var stale []string
+	p.store.Range(func(code string, peer PeerInfo) bool {
+		if now.Sub(peer.LastSeen) > ttl || (!peer.Expires.IsZero() && now.After(peer.Expires)) {
+			stale = append(stale, code)
+		}
+		return true
+	})
+	for _, code := range stale {
+		if err := p.store.Delete(code); err != nil {
+			slog.Error("Removing stale peer", "code", code, "err", err)
+		}
+	}
+	return stale
+}
+
+// Close closes the store, which flushes a persistent one to disk.
+func (p *Peers) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.store.Close()
+}
+
+func validToken(peer PeerInfo, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(peer.Token), []byte(token)) == 1
+}
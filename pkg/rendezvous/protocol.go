@@ -0,0 +1,222 @@
+// Package rendezvous implements the rendezvous server and the protocol spoken
+// between it and the peers.
+//
+// The directdrop-server command is a thin wrapper around Server; other
+// programs can embed one the same way:
+//
+//	server := rendezvous.NewServer(rendezvous.NewPeers(rendezvous.NewMemoryStore()))
+//	server.Relay = rendezvous.NewRelay(0, time.Minute, 10*time.Minute)
+//	server.Hooks.OnRegister = func(code string, peer rendezvous.PeerInfo, updated bool) { ... }
+//	go server.Serve(listener)
+//	...
+//	server.Shutdown(ctx)
+//
+// Every message is a single line of JSON. A client opens with HELLO to agree
+// on a protocol version, then sends requests that are each answered by one
+// response of the same type, or by an ERROR response carrying a numeric code:
+//
+//	-> {"type":"HELLO","version":2}
+//	<- {"type":"HELLO","version":2}
+//	-> {"type":"LOOK","code":"7"}
+//	<- {"type":"ERROR","error":{"code":404,"message":"code not found"}}
+//
+// Since version 2 an ADD without a code lets the server pick the lowest free
+// nameplate, which the response carries:
+//
+//	-> {"type":"ADD","address":"203.0.113.5:4000"}
+//	<- {"type":"ADD","code":"7","token":"..."}
+//
+// After a RELAY request is answered the connection carries the peers' own
+// traffic, so clients read responses without buffering past the newline.
+package rendezvous
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/pkg"
+)
+
+const (
+	Version    = 2 // protocol version spoken by this package
+	MinVersion = 1 // oldest version still accepted
+
+	// MaxMessage is the longest line accepted as a single message.
+	MaxMessage = 4096
+)
+
+// Message types. A response has the type of the request it answers.
+const (
+	TypeHello = "HELLO" // negotiate the protocol version
+	TypeAdd   = "ADD"   // register or update a share
+	TypeLook  = "LOOK"  // look up a share's address
+	TypePing  = "PING"  // keep a registration alive
+	TypeDel   = "DEL"   // remove a registration
+	TypeRelay = "RELAY" // splice the connection to the other peer
+	TypeError = "ERROR" // a request failed
+)
+
+// RoleShare marks a RELAY request from a sharer parking a standby connection.
+const RoleShare = "share"
+
+// Request is a message sent by a client.
+type Request struct {
+	Type      string    `json:"type"`
+	Version   int       `json:"version,omitempty"`   // HELLO: highest version the client speaks
+	Code      string    `json:"code,omitempty"`      // nameplate of the share, empty in ADD to have one allocated
+	Address   string    `json:"address,omitempty"`   // ADD: where receivers reach the sharer
+	Token     string    `json:"token,omitempty"`     // owner token of the registration
+	Uses      int       `json:"uses,omitempty"`      // ADD: completed downloads allowed, 0 for unlimited
+	Downloads int       `json:"downloads,omitempty"` // PING: downloads the sharer completed so far
+	Expires   time.Time `json:"expires,omitzero"`    // ADD: when the share stops being handed out
+	Role      string    `json:"role,omitempty"`      // RELAY: RoleShare for a sharer's standby
+}
+
+// Response is a message sent by the server.
+type Response struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"` // HELLO: version both sides speak
+	Code    string `json:"code,omitempty"`    // nameplate the request was about
+	Address string `json:"address,omitempty"` // LOOK: the sharer's address
+	Token   string `json:"token,omitempty"`   // ADD: owner token of the registration
+	Updated bool   `json:"updated,omitempty"` // ADD: an existing registration was updated
+	Error   *Error `json:"error,omitempty"`   // ERROR: what went wrong
+}
+
+// Error codes carried by ERROR responses. They follow the HTTP status codes
+// of the same meaning.
+const (
+	BadRequest         = 400
+	InvalidToken       = 403
+	NotFound           = 404
+	CodeInUse          = 409
+	Revoked            = 410
+	TooManyRequests    = 429
+	UnsupportedVersion = 426
+	Internal           = 500
+	RelayDisabled      = 501
+	RelayUnavailable   = 503
+)
+
+// Error is an error reported by the server.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// Is makes errors.Is match any Error with the same code, so callers can test
+// against the sentinels below whatever the message.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Errorf returns an Error with code and a formatted message.
+func Errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+var (
+	ErrBadRequest         = &Error{BadRequest, "bad request"}
+	ErrInvalidToken       = &Error{InvalidToken, "invalid token"}
+	ErrNotFound           = &Error{NotFound, "code not found"}
+	ErrCodeInUse          = &Error{CodeInUse, "code in use"}
+	ErrRevoked            = &Error{Revoked, "share revoked"}
+	ErrTooManyRequests    = &Error{TooManyRequests, "too many requests"}
+	ErrUnsupportedVersion = &Error{UnsupportedVersion, "unsupported protocol version"}
+	ErrInternal           = &Error{Internal, "internal error"}
+	ErrRelayDisabled      = &Error{RelayDisabled, "relay disabled"}
+	ErrRelayUnavailable   = &Error{RelayUnavailable, "no relay available for code"}
+)
+
+// Write sends v as a single JSON line.
+func Write(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Negotiate returns the version to speak with a client that offered version.
+func Negotiate(version int) (int, error) {
+	if version < MinVersion {
+		return 0, Errorf(UnsupportedVersion, "unsupported protocol version %d, need at least %d", version, MinVersion)
+	}
+	return min(version, Version), nil
+}
+
+// Client sends requests to the rendezvous server over a connection on which
+// HELLO has been exchanged.
+type Client struct {
+	conn    net.Conn
+	Version int // negotiated protocol version
+}
+
+// Dial connects to the rendezvous server at addr, over TLS when tlsConfig is
+// set, and negotiates the protocol version. timeout bounds the dial and HELLO
+// alike.
+func Dial(addr string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, *Client, error) {
+	conn, err := pkg.DialServer(addr, timeout, tlsConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to server %s: %w", addr, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	client, err := Hello(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to greet server %s: %w", addr, err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, client, nil
+}
+
+// Hello negotiates the protocol version on conn and returns a client for it.
+func Hello(conn net.Conn) (*Client, error) {
+	c := &Client{conn: conn}
+	resp, err := c.Do(Request{Type: TypeHello, Version: Version})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Version < MinVersion || resp.Version > Version {
+		return nil, fmt.Errorf("server chose unsupported protocol version %d", resp.Version)
+	}
+	c.Version = resp.Version
+	return c, nil
+}
+
+// Do sends req and waits for its response. An ERROR response is returned as
+// its *Error.
+func (c *Client) Do(req Request) (*Response, error) {
+	if err := Write(c.conn, req); err != nil {
+		return nil, err
+	}
+
+	// Read byte by byte so nothing past the response is consumed, which
+	// matters once a RELAY connection starts carrying peer traffic.
+	line, err := pkg.ReadLine(c.conn, MaxMessage)
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		return nil, fmt.Errorf("invalid response from server: %w", err)
+	}
+	switch {
+	case resp.Type == TypeError && resp.Error != nil:
+		return nil, resp.Error
+	case resp.Type != req.Type:
+		return nil, fmt.Errorf("unexpected %s response to %s", resp.Type, req.Type)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,99 @@
+package rendezvous
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := Errorf(NotFound, "no share registered as %s", "7")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("%v does not match ErrNotFound", err)
+	}
+	if errors.Is(err, ErrCodeInUse) {
+		t.Errorf("%v matches ErrCodeInUse", err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	if v, err := Negotiate(Version + 3); err != nil || v != Version {
+		t.Errorf("Negotiate(newer) = %d, %v", v, err)
+	}
+	if _, err := Negotiate(0); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Negotiate(0) = %v, expected ErrUnsupportedVersion", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	// Answer HELLO, then fail the LOOK and send trailing bytes that the
+	// client must leave unread.
+	go func() {
+		defer serverConn.Close()
+		reader := bufio.NewReader(serverConn)
+		for _, resp := range []Response{
+			{Type: TypeHello, Version: Version},
+			{Type: TypeError, Error: ErrNotFound},
+		} {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var req Request
+			json.Unmarshal(line, &req)
+			Write(serverConn, resp)
+		}
+		serverConn.Write([]byte("peer data"))
+	}()
+
+	client, err := Hello(clientConn)
+	if err != nil {
+		t.Fatalf("Hello failed: %v", err)
+	}
+	if client.Version != Version {
+		t.Errorf("negotiated version %d, expected %d", client.Version, Version)
+	}
+	if _, err := client.Do(Request{Type: TypeLook, Code: "7"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	buf := make([]byte, len("peer data"))
+	if _, err := clientConn.Read(buf); err != nil || string(buf) != "peer data" {
+		t.Errorf("bytes after the response were consumed: %q, %v", buf, err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	addr := startServer(t, newPeers(), nil)
+	conn, client, err := Dial(addr, time.Second, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if client.Version != Version {
+		t.Errorf("negotiated version %d, expected %d", client.Version, Version)
+	}
+	if _, err := client.Do(Request{Type: TypeLook, Code: "7"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// A listener that never answers HELLO makes Dial give up after timeout.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	start := time.Now()
+	if _, _, err := Dial(silent.Addr().String(), 100*time.Millisecond, nil); err == nil {
+		t.Error("Dial succeeded without HELLO")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Dial took %v to give up", elapsed)
+	}
+}
@@ -0,0 +1,213 @@
+package rendezvous
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// standbyWait is how long a receiver asking for a relay waits for the sharer's
+// standby connection, which is briefly absent while the sharer replaces one
+// that was just used.
+const standbyWait = 5 * time.Second
+
+// Relay splices receivers that cannot reach a sharer directly onto a standby
+// connection the sharer keeps open to the server. Both peers only ever dial
+// out, so it works when the sharer is behind NAT or a firewall. The peers run
+// their end-to-end encryption over the spliced stream, so the relay only sees
+// ciphertext.
+type Relay struct {
+	Rate    int64         // bytes per second in each direction, 0 for unlimited
+	Timeout time.Duration // maximum lifetime of a relayed session
+	MaxIdle time.Duration // how long a standby connection may wait for a receiver
+
+	mu       sync.Mutex
+	standbys map[string]*standby
+	closed   bool
+	sessions atomic.Int64 // relayed sessions in progress
+}
+
+type standby struct {
+	conn net.Conn
+	done chan struct{} // closed once the standby has been used or dropped
+}
+
+func NewRelay(rate int64, timeout, maxIdle time.Duration) *Relay {
+	return &Relay{
+		Rate:     rate,
+		Timeout:  timeout,
+		MaxIdle:  maxIdle,
+		standbys: make(map[string]*standby),
+	}
+}
+
+// Standby parks a sharer connection for shareCode until a receiver is paired
+// with it and the session ends, or MaxIdle passes. It blocks for the lifetime
+// of the connection.
+func (r *Relay) Standby(shareCode string, conn net.Conn) {
+	sb := &standby{conn: conn, done: make(chan struct{})}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	if old, ok := r.standbys[shareCode]; ok {
+		close(old.done)
+	}
+	r.standbys[shareCode] = sb
+	r.mu.Unlock()
+
+	slog.Debug("Relay standby", "code", shareCode, "client", conn.RemoteAddr().String())
+
+	select {
+	case <-sb.done:
+	case <-time.After(r.MaxIdle):
+		r.mu.Lock()
+		idle := r.standbys[shareCode] == sb
+		if idle {
+			delete(r.standbys, shareCode)
+			close(sb.done)
+		}
+		r.mu.Unlock()
+		if !idle {
+			// Connect claimed it just now, and the caller closes conn once
+			// Standby returns, so wait for the session to end.
+			<-sb.done
+		}
+	}
+}
+
+// Connect pairs a receiver connection with the standby for shareCode and
+// copies bytes between them until either side closes or the session times
+// out. It returns the bytes relayed up to the sharer and down to the receiver.
+func (r *Relay) Connect(shareCode string, conn net.Conn) (up, down int64, err error) {
+	sb := r.claim(shareCode)
+	if sb == nil {
+		return 0, 0, Errorf(RelayUnavailable, "no relay available for code %s", shareCode)
+	}
+	defer close(sb.done)
+
+	paired := Response{Type: TypeRelay, Code: shareCode}
+	if err := Write(sb.conn, paired); err != nil {
+		return 0, 0, fmt.Errorf("sharer standby went away: %w", err)
+	}
+	if err := Write(conn, paired); err != nil {
+		return 0, 0, err
+	}
+
+	r.sessions.Add(1)
+	defer r.sessions.Add(-1)
+
+	slog.Info("Relaying", "code", shareCode, "sharer", sb.conn.RemoteAddr().String(), "receiver", conn.RemoteAddr().String())
+	start := time.Now()
+	if r.Timeout > 0 {
+		deadline := start.Add(r.Timeout)
+		sb.conn.SetDeadline(deadline)
+		conn.SetDeadline(deadline)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		up = r.copy(sb.conn, conn)
+		sb.conn.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		down = r.copy(conn, sb.conn)
+		conn.Close()
+	}()
+	wg.Wait()
+
+	slog.Info("Relay finished", "code", shareCode, "duration", time.Since(start).Round(time.Second), "up", up, "down", down)
+	return up, down, nil
+}
+
+// Drop closes the standby connection for shareCode, if any.
+func (r *Relay) Drop(shareCode string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sb, ok := r.standbys[shareCode]; ok {
+		delete(r.standbys, shareCode)
+		close(sb.done)
+	}
+}
+
+// Close drops every standby connection and refuses new ones. Sessions in
+// progress run on.
+func (r *Relay) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for shareCode, sb := range r.standbys {
+		delete(r.standbys, shareCode)
+		close(sb.done)
+	}
+}
+
+// Standbys returns the number of sharers waiting for a receiver.
+func (r *Relay) Standbys() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.standbys)
+}
+
+// Sessions returns the number of relayed sessions in progress.
+func (r *Relay) Sessions() int {
+	return int(r.sessions.Load())
+}
+
+// claim removes and returns the standby for shareCode, waiting up to
+// standbyWait for one to appear.
+func (r *Relay) claim(shareCode string) *standby {
+	deadline := time.Now().Add(standbyWait)
+	for {
+		r.mu.Lock()
+		sb, ok := r.standbys[shareCode]
+		if ok {
+			delete(r.standbys, shareCode)
+		}
+		closed := r.closed
+		r.mu.Unlock()
+
+		if ok || closed || time.Now().After(deadline) {
+			return sb
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// copy copies src to dst, throttled to Rate bytes per second when set.
+func (r *Relay) copy(dst io.Writer, src io.Reader) int64 {
+	if r.Rate <= 0 {
+		n, _ := io.Copy(dst, src)
+		return n
+	}
+
+	buf := make([]byte, 32*1024)
+	start := time.Now()
+	var total int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return total
+			}
+			total += int64(n)
+			// Sleep until the bytes sent so far fit within the rate.
+			expected := time.Duration(float64(total) / float64(r.Rate) * float64(time.Second))
+			if ahead := expected - time.Since(start); ahead > 0 {
+				time.Sleep(ahead)
+			}
+		}
+		if err != nil {
+			return total
+		}
+	}
+}
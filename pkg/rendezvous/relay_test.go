@@ -0,0 +1,94 @@
+package rendezvous_test
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sujalshah-bit/DirectDrop/internal/p2p"
+	"github.com/sujalshah-bit/DirectDrop/pkg/rendezvous"
+)
+
+// TestRelayTransfer shares a file with a receiver that cannot reach the
+// sharer directly, so the transfer has to go through the relay.
+func TestRelayTransfer(t *testing.T) {
+	data := bytes.Repeat([]byte("relayed "), 100000)
+	path := filepath.Join(t.TempDir(), "relayed.txt")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := rendezvous.NewServer(rendezvous.NewPeers(rendezvous.NewMemoryStore()))
+	server.Relay = rendezvous.NewRelay(0, time.Minute, time.Minute)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(t.Context())
+	addr := listener.Addr().String()
+
+	flags := []*string{new(string), new(string), new(string), &path}
+	sharer := p2p.NewSharerTCPServer("127.0.0.1:0", addr, 5*time.Second, flags)
+	if err := sharer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sharer.Stop()
+	code, err := sharer.SendCodeToServer()
+	if err != nil {
+		t.Fatalf("SendCodeToServer failed: %v", err)
+	}
+	sharer.StartRelay()
+
+	t.Chdir(t.TempDir())
+	receiver := p2p.NewTCPClient(addr, 5*time.Second)
+	defer receiver.Close()
+	if _, err := receiver.ReceiveCode(code); err != nil {
+		t.Fatalf("ReceiveCode failed: %v", err)
+	}
+	// Nothing listens on port 1, so the direct dial is refused.
+	if err := receiver.RequestData("127.0.0.1:1"); err != nil {
+		t.Fatalf("RequestData failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join("download", "relayed.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("relayed file differs from source")
+	}
+}
+
+// TestStandbyOutlivesMaxIdleWhilePaired checks that a standby paired with a
+// receiver keeps its connection open past MaxIdle until the session ends.
+func TestStandbyOutlivesMaxIdleWhilePaired(t *testing.T) {
+	relay := rendezvous.NewRelay(0, time.Minute, 200*time.Millisecond)
+	sharerEnd, sharer := net.Pipe()
+	receiverEnd, receiver := net.Pipe()
+	defer sharer.Close()
+	defer receiver.Close()
+
+	go func() {
+		relay.Standby("7", sharerEnd)
+		sharerEnd.Close() // as the server does once Standby returns
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go relay.Connect("7", receiverEnd)
+
+	buf := make([]byte, 256)
+	for _, conn := range []net.Conn{sharer, receiver} {
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("reading the pairing response: %v", err)
+		}
+	}
+
+	time.Sleep(400 * time.Millisecond) // past MaxIdle
+	go receiver.Write([]byte("ping"))
+	sharer.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := sharer.Read(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("sharer read %q, %v after MaxIdle; expected the session to continue", buf[:n], err)
+	}
+}
@@ -0,0 +1,413 @@
+package rendezvous
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve once Shutdown has been called.
+var ErrServerClosed = errors.New("rendezvous server closed")
+
+// Server answers the rendezvous requests of peers. Set the exported fields
+// before calling Serve.
+type Server struct {
+	Relay      *Relay        // splices peers that cannot connect directly, nil to disable
+	Limiter    *Limiter      // abuse protection, nil for no limits
+	PeerTTL    time.Duration // registrations not seen for this long are collected
+	GCInterval time.Duration // how often stale registrations are collected
+	Hooks      Hooks
+
+	peers *Peers
+	conns connStats
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	clients   map[net.Conn]bool // true once the connection carries relayed traffic
+	wg        sync.WaitGroup    // running client handlers
+	gcOnce    sync.Once
+	closing   bool
+	done      chan struct{} // closed by Shutdown
+}
+
+// connStats counts client connections.
+type connStats struct {
+	active atomic.Int64
+	total  atomic.Int64
+}
+
+func NewServer(peers *Peers) *Server {
+	return &Server{
+		PeerTTL:    7 * time.Minute,
+		GCInterval: time.Minute,
+		peers:      peers,
+		listeners:  make(map[net.Listener]struct{}),
+		clients:    make(map[net.Conn]bool),
+		done:       make(chan struct{}),
+	}
+}
+
+// Peers returns the registrations the server hands out.
+func (s *Server) Peers() *Peers {
+	return s.peers
+}
+
+// Connections returns the number of open client connections and of all
+// connections accepted so far.
+func (s *Server) Connections() (active, total int64) {
+	return s.conns.active.Load(), s.conns.total.Load()
+}
+
+// Serve accepts clients on listener and handles each in its own goroutine
+// until Shutdown is called, after which it returns ErrServerClosed. The first
+// call also starts collecting stale registrations.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		s.mu.Unlock()
+	}()
+
+	s.gcOnce.Do(func() { go s.gc() })
+
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Errors such as running out of file descriptors pass, so wait
+			// and try again instead of giving up on every client.
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			slog.Error("Accepting client", "err", err, "retry", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(conn)
+			s.handleClient(conn)
+		}()
+	}
+}
+
+// track registers a client connection, unless the server is shutting down.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.clients[conn] = false
+	s.wg.Add(1)
+	s.conns.active.Add(1)
+	s.conns.total.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.clients, conn)
+	s.mu.Unlock()
+	s.conns.active.Add(-1)
+	s.wg.Done()
+}
+
+// relaying marks whether conn carries relayed traffic, which Shutdown lets
+// run to completion instead of cutting it off after the request in flight.
+func (s *Server) relaying(conn net.Conn, relayed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[conn] = relayed
+}
+
+// Shutdown stops the server gracefully. It closes the listeners and stops
+// collecting stale registrations, lets every client finish the request in
+// flight and relayed sessions run to completion, and then closes the store so
+// a persistent one is flushed to disk. If ctx ends first the remaining
+// connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closing = true
+	close(s.done)
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	// Idle clients are blocked reading their next request; wake them up so
+	// their handlers return once the request in flight is answered.
+	for conn, relayed := range s.clients {
+		if !relayed {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+	if s.Relay != nil {
+		s.Relay.Close()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.mu.Lock()
+		slog.Warn("Closing connections that did not finish in time", "clients", len(s.clients))
+		for conn := range s.clients {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-drained
+	}
+
+	if closeErr := s.peers.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("closing store: %w", closeErr)
+	}
+	return err
+}
+
+func (s *Server) handleClient(conn net.Conn) {
+	peers, relay, limiter := s.peers, s.Relay, s.Limiter
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, MaxMessage), MaxMessage)
+	clientAddr, ip := conn.RemoteAddr().String(), clientIP(conn)
+
+	if err := limiter.Open(ip); err != nil {
+		s.reply(conn, err)
+		slog.Debug("Refused client", "client", clientAddr, "err", err)
+		return
+	}
+	defer limiter.Close(ip)
+	slog.Debug("Client connected", "client", clientAddr)
+
+	version := 0 // negotiated with HELLO
+	var connRequests bucket
+	for scanner.Scan() {
+		if err := limiter.AllowConn(&connRequests); err != nil {
+			s.reply(conn, err)
+			continue
+		}
+		if err := limiter.Allow(ip); err != nil {
+			s.reply(conn, err)
+			if limiter.Banned(ip) {
+				return
+			}
+			continue
+		}
+
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			s.reply(conn, Errorf(BadRequest, "invalid message: %v", err))
+			continue
+		}
+		s.Hooks.request(req.Type)
+		if version == 0 && req.Type != TypeHello {
+			s.reply(conn, Errorf(BadRequest, "HELLO required"))
+			continue
+		}
+		// A new registration may leave the code to the server.
+		if req.Code == "" && req.Type != TypeHello && (req.Type != TypeAdd || req.Token != "") {
+			s.reply(conn, Errorf(BadRequest, "code required"))
+			continue
+		}
+
+		resp := Response{Type: req.Type, Code: req.Code}
+		switch req.Type {
+		case TypeHello:
+			v, err := Negotiate(req.Version)
+			if err != nil {
+				s.reply(conn, err)
+				continue
+			}
+			version = v
+			s.reply(conn, Response{Type: req.Type, Version: version})
+		case TypeAdd:
+			if req.Address == "" || req.Uses < 0 {
+				s.reply(conn, Errorf(BadRequest, "ADD needs an address and uses must not be negative"))
+				continue
+			}
+			peer := PeerInfo{Address: req.Address, Token: req.Token, MaxUses: req.Uses, Expires: req.Expires}
+			var owner string
+			var err error
+			if req.Code == "" {
+				resp.Code, owner, err = peers.Allocate(peer)
+			} else {
+				owner, err = peers.Add(req.Code, peer)
+			}
+			if err != nil {
+				s.reply(conn, err)
+				slog.Info("Rejected registration", "code", req.Code, "client", clientAddr, "err", err)
+				continue
+			}
+			resp.Token = owner
+			resp.Updated = req.Token != ""
+			// Counted once the code is known, which a token may also claim
+			// afresh, and undone if ip holds too many codes already.
+			if err := limiter.Register(ip, resp.Code); err != nil {
+				if err := peers.Delete(resp.Code, owner); err != nil {
+					slog.Error("Removing refused registration", "code", resp.Code, "err", err)
+				}
+				s.reply(conn, err)
+				slog.Info("Rejected registration", "code", resp.Code, "client", clientAddr, "err", err)
+				continue
+			}
+			s.Hooks.register(resp.Code, peer, resp.Updated)
+			s.reply(conn, resp)
+			if resp.Updated {
+				slog.Info("Updated code", "code", resp.Code, "addr", peer.Address)
+			} else {
+				slog.Info("Registered code", "code", resp.Code, "addr", peer.Address, "uses", peer.MaxUses, "expires", peer.Expires)
+			}
+		case TypeLook:
+			peer, exist := s.look(conn, ip, req.Code)
+			if !exist {
+				continue
+			}
+			resp.Address = peer.Address
+			s.reply(conn, resp)
+			slog.Info("Lookup", "code", req.Code, "found", peer.Address)
+		case TypePing:
+			if err := peers.Refresh(req.Code, req.Token, req.Downloads); err != nil {
+				s.reply(conn, err)
+				continue
+			}
+			s.reply(conn, resp)
+			slog.Debug("Refreshed code", "code", req.Code)
+		case TypeDel:
+			if err := peers.Delete(req.Code, req.Token); err != nil {
+				s.reply(conn, err)
+				continue
+			}
+			limiter.Unregister(req.Code)
+			s.Hooks.delete(req.Code)
+			s.reply(conn, resp)
+			slog.Info("Deleted code", "code", req.Code)
+		case TypeRelay:
+			// From here on the connection carries the peers' own traffic.
+			if relay == nil {
+				s.reply(conn, ErrRelayDisabled)
+				continue
+			}
+			if req.Role == RoleShare {
+				// Only the owner may stand by for a code.
+				if err := peers.Authorize(req.Code, req.Token); err != nil {
+					s.reply(conn, err)
+					continue
+				}
+				s.relaying(conn, true)
+				relay.Standby(req.Code, conn)
+				return
+			}
+			// A receiver reaches the sharer through the relay instead of
+			// its address, so the code is looked up as for LOOK.
+			if _, exist := s.look(conn, ip, req.Code); !exist {
+				continue
+			}
+			s.relaying(conn, true)
+			up, down, err := relay.Connect(req.Code, conn)
+			if err != nil {
+				s.relaying(conn, false)
+				s.reply(conn, err)
+				continue
+			}
+			s.Hooks.relay(req.Code, up, down)
+			return
+		default:
+			s.reply(conn, Errorf(BadRequest, "unknown request type %q", req.Type))
+		}
+	}
+}
+
+// look returns the registration for shareCode on behalf of a receiver at ip,
+// applying the lookup limits. If the lookup is refused or the code is not
+// available it replies with the error and reports false.
+func (s *Server) look(conn net.Conn, ip, shareCode string) (PeerInfo, bool) {
+	if err := s.Limiter.AllowLook(ip); err != nil {
+		s.reply(conn, err)
+		return PeerInfo{}, false
+	}
+	peer, exist := s.peers.Look(shareCode)
+	if !exist {
+		if s.Limiter.Miss(ip) {
+			s.Hooks.ban(ip)
+		}
+		s.Hooks.lookup(shareCode, false)
+		s.reply(conn, ErrNotFound)
+		slog.Debug("Lookup miss", "code", shareCode, "client", conn.RemoteAddr().String())
+		return PeerInfo{}, false
+	}
+	s.Hooks.lookup(shareCode, true)
+	return peer, true
+}
+
+// reply sends v to the client. Errors are sent as an ERROR response, with
+// anything that is not already a protocol error reported as internal.
+func (s *Server) reply(conn net.Conn, v any) {
+	if err, ok := v.(error); ok {
+		var protocolErr *Error
+		if !errors.As(err, &protocolErr) {
+			slog.Error("Request failed", "err", err)
+			protocolErr = ErrInternal
+		}
+		s.Hooks.error(protocolErr)
+		v = Response{Type: TypeError, Error: protocolErr}
+	}
+	if err := Write(conn, v); err != nil {
+		slog.Debug("Failed to reply", "client", conn.RemoteAddr().String(), "err", err)
+	}
+}
+
+// gc periodically drops registrations that have not been seen within
+// PeerTTL, until Shutdown.
+func (s *Server) gc() {
+	ticker := time.NewTicker(s.GCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		for _, code := range s.peers.Collect(s.PeerTTL) {
+			slog.Info("Removing stale peer", "code", code)
+			s.Limiter.Unregister(code)
+			s.Hooks.collect(code)
+		}
+	}
+}
@@ -0,0 +1,609 @@
+package rendezvous
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer serves peers on a local listener and returns its address.
+func startServer(t *testing.T, peers *Peers, relay *Relay) string {
+	t.Helper()
+	return startLimitedServer(t, peers, relay, nil)
+}
+
+// startLimitedServer is startServer with limiter applied to clients.
+func startLimitedServer(t *testing.T, peers *Peers, relay *Relay, limiter *Limiter) string {
+	t.Helper()
+	server := NewServer(peers)
+	server.Relay = relay
+	server.Limiter = limiter
+	return serve(t, server)
+}
+
+// serve runs server on a local listener until the test ends and returns its
+// address.
+func serve(t *testing.T, server *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return listener.Addr().String()
+}
+
+// testClient sends one message at a time and returns the response.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialServer connects to addr and completes HELLO.
+func dialServer(t *testing.T, addr string) *testClient {
+	t.Helper()
+	c := dialRaw(t, addr)
+	if resp := c.send(Request{Type: TypeHello, Version: Version}); resp.Version != Version {
+		t.Fatalf("HELLO: got %+v", resp)
+	}
+	return c
+}
+
+// dialRaw connects to addr without HELLO.
+func dialRaw(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(req Request) Response {
+	c.t.Helper()
+	if err := Write(c.conn, req); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+func (c *testClient) sendLine(line string) Response {
+	c.t.Helper()
+	if _, err := fmt.Fprintln(c.conn, line); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+func (c *testClient) read() Response {
+	c.t.Helper()
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		c.t.Fatalf("invalid response %q: %v", line, err)
+	}
+	return resp
+}
+
+// add registers code and returns the owner token.
+func (c *testClient) add(code, addr string) string {
+	c.t.Helper()
+	resp := c.send(Request{Type: TypeAdd, Code: code, Address: addr})
+	if resp.Type != TypeAdd || resp.Token == "" {
+		c.t.Fatalf("ADD %s: got %+v", code, resp)
+	}
+	return resp.Token
+}
+
+// errorCode returns the error code of resp, or 0 if it is not an error.
+func errorCode(resp Response) int {
+	if resp.Type != TypeError || resp.Error == nil {
+		return 0
+	}
+	return resp.Error.Code
+}
+
+func newPeers() *Peers {
+	return NewPeers(NewMemoryStore())
+}
+
+// get returns the registration for code as stored.
+func (p *Peers) get(code string) (PeerInfo, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.store.Get(code)
+}
+
+func TestHandleClient(t *testing.T) {
+	peers := newPeers()
+	client := dialServer(t, startServer(t, peers, nil))
+
+	tests := []struct {
+		name        string
+		input       Request
+		wantAddress string
+		wantError   int
+		shareCode   string
+		expectPeer  bool
+	}{
+		{
+			name:       "ADD command",
+			input:      Request{Type: "ADD", Code: "abc123", Address: "192.168.1.100:8080"},
+			shareCode:  "abc123",
+			expectPeer: true,
+		},
+		{
+			name:        "LOOK command with existing code",
+			input:       Request{Type: "LOOK", Code: "abc123"},
+			wantAddress: "192.168.1.100:8080",
+			shareCode:   "abc123",
+			expectPeer:  true,
+		},
+		{
+			name:       "LOOK command with non-existent code",
+			input:      Request{Type: "LOOK", Code: "nonexistent"},
+			wantError:  NotFound,
+			shareCode:  "nonexistent",
+			expectPeer: false,
+		},
+		{
+			name:       "Invalid command",
+			input:      Request{Type: "INVALID", Code: "abc123"},
+			wantError:  BadRequest,
+			shareCode:  "abc123",
+			expectPeer: true,
+		},
+		{
+			name:       "Malformed command",
+			input:      Request{Type: "ADD"},
+			wantError:  BadRequest,
+			shareCode:  "",
+			expectPeer: false,
+		},
+		{
+			name:       "ADD without address",
+			input:      Request{Type: "ADD", Code: "xyz789"},
+			wantError:  BadRequest,
+			shareCode:  "xyz789",
+			expectPeer: false,
+		},
+		{
+			name:       "ADD with negative uses",
+			input:      Request{Type: "ADD", Code: "xyz789", Address: "10.0.0.1:9000", Uses: -1},
+			wantError:  BadRequest,
+			shareCode:  "xyz789",
+			expectPeer: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := client.send(tt.input)
+			if got := errorCode(resp); got != tt.wantError {
+				t.Errorf("Expected error %d, got %+v", tt.wantError, resp)
+			}
+			if tt.wantError == 0 && resp.Type != tt.input.Type {
+				t.Errorf("Expected a %s response, got %+v", tt.input.Type, resp)
+			}
+			if resp.Address != tt.wantAddress {
+				t.Errorf("Expected address '%s', got '%s'", tt.wantAddress, resp.Address)
+			}
+
+			_, exists := peers.get(tt.shareCode)
+			if exists != tt.expectPeer {
+				t.Errorf("Expected peer %s registered: %v, got %v", tt.shareCode, tt.expectPeer, exists)
+			}
+		})
+	}
+}
+
+func TestHello(t *testing.T) {
+	addr := startServer(t, newPeers(), nil)
+	client := dialRaw(t, addr)
+
+	if got := errorCode(client.send(Request{Type: TypeLook, Code: "7"})); got != BadRequest {
+		t.Errorf("request before HELLO: got error %d", got)
+	}
+	if got := errorCode(client.sendLine("LOOK 7")); got != BadRequest {
+		t.Errorf("line that is not JSON: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: TypeHello})); got != UnsupportedVersion {
+		t.Errorf("HELLO without a version: got error %d", got)
+	}
+
+	// A newer client is answered with the version the server speaks.
+	resp := client.send(Request{Type: TypeHello, Version: Version + 1})
+	if resp.Type != TypeHello || resp.Version != Version {
+		t.Fatalf("HELLO from a newer client: got %+v", resp)
+	}
+	if got := errorCode(client.send(Request{Type: TypeLook, Code: "7"})); got != NotFound {
+		t.Errorf("LOOK after HELLO: got error %d", got)
+	}
+}
+
+func TestAddOwnership(t *testing.T) {
+	peers := newPeers()
+	addr := startServer(t, peers, nil)
+	owner := dialServer(t, addr)
+	other := dialServer(t, addr)
+
+	token := owner.add("7", "10.0.0.1:9000")
+
+	if got := errorCode(other.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.66:9000"})); got != CodeInUse {
+		t.Errorf("duplicate ADD: got error %d", got)
+	}
+	if got := errorCode(other.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.66:9000", Token: "forged"})); got != InvalidToken {
+		t.Errorf("ADD with wrong token: got error %d", got)
+	}
+	if got := other.send(Request{Type: "LOOK", Code: "7"}); got.Address != "10.0.0.1:9000" {
+		t.Errorf("registration was hijacked: LOOK returned %+v", got)
+	}
+
+	resp := owner.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.2:9000", Token: token})
+	if resp.Type != TypeAdd || !resp.Updated || resp.Token != token {
+		t.Errorf("ADD with owner token: got %+v", resp)
+	}
+	if got := other.send(Request{Type: "LOOK", Code: "7"}); got.Address != "10.0.0.2:9000" {
+		t.Errorf("update not applied: LOOK returned %+v", got)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	peers := newPeers()
+	addr := startServer(t, peers, nil)
+	client := dialServer(t, addr)
+
+	allocate := func() Response {
+		t.Helper()
+		resp := client.send(Request{Type: "ADD", Address: "10.0.0.1:9000"})
+		if resp.Type != TypeAdd || resp.Token == "" {
+			t.Fatalf("ADD without a code: got %+v", resp)
+		}
+		return resp
+	}
+
+	client.add("2", "10.0.0.2:9000")
+	first := allocate()
+	if first.Code != "1" {
+		t.Errorf("first allocated code %q, expected 1", first.Code)
+	}
+	if got := allocate().Code; got != "3" {
+		t.Errorf("allocated code %q, expected the free 3", got)
+	}
+	if got := client.send(Request{Type: "LOOK", Code: "1"}); got.Address != "10.0.0.1:9000" {
+		t.Errorf("allocated code not registered: LOOK returned %+v", got)
+	}
+
+	client.send(Request{Type: "DEL", Code: "1", Token: first.Token})
+	if got := allocate().Code; got != "1" {
+		t.Errorf("allocated code %q after deleting 1, expected 1 again", got)
+	}
+	if got := errorCode(client.send(Request{Type: "ADD", Address: "10.0.0.1:9000", Token: first.Token})); got != BadRequest {
+		t.Errorf("update without a code: got error %d", got)
+	}
+}
+
+func TestRelayStandbyRequiresToken(t *testing.T) {
+	peers := newPeers()
+	addr := startServer(t, peers, NewRelay(0, time.Minute, time.Minute))
+	client := dialServer(t, addr)
+
+	token := client.add("7", "10.0.0.1:9000")
+	standby := Request{Type: TypeRelay, Code: "7", Token: "forged", Role: RoleShare}
+	if got := errorCode(client.send(standby)); got != InvalidToken {
+		t.Errorf("standby with wrong token: got error %d", got)
+	}
+	standby.Code, standby.Token = "8", token
+	if got := errorCode(client.send(standby)); got != InvalidToken {
+		t.Errorf("standby for someone else's code: got error %d", got)
+	}
+}
+
+func TestRelayRequiresAvailableCode(t *testing.T) {
+	peers := newPeers()
+	addr := startServer(t, peers, NewRelay(0, time.Minute, time.Minute))
+	owner := dialServer(t, addr)
+	receiver := dialServer(t, addr)
+
+	token := owner.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.1:9000", Uses: 1}).Token
+	owner.send(Request{Type: "PING", Code: "7", Token: token, Downloads: 1})
+	if got := errorCode(receiver.send(Request{Type: TypeRelay, Code: "7"})); got != NotFound {
+		t.Errorf("RELAY to a used up code: got error %d", got)
+	}
+	owner.add("8", "10.0.0.1:9000")
+	if err := peers.Revoke("8"); err != nil {
+		t.Fatal(err)
+	}
+	if got := errorCode(receiver.send(Request{Type: TypeRelay, Code: "8"})); got != NotFound {
+		t.Errorf("RELAY to a revoked code: got error %d", got)
+	}
+	if got := errorCode(receiver.send(Request{Type: TypeRelay, Code: "9"})); got != NotFound {
+		t.Errorf("RELAY to an unknown code: got error %d", got)
+	}
+}
+
+func TestRelayDisabled(t *testing.T) {
+	client := dialServer(t, startServer(t, newPeers(), nil))
+	if got := errorCode(client.send(Request{Type: TypeRelay, Code: "7"})); got != RelayDisabled {
+		t.Errorf("RELAY without a relay: got error %d", got)
+	}
+}
+
+func TestPing(t *testing.T) {
+	peers := newPeers()
+	client := dialServer(t, startServer(t, peers, nil))
+
+	token := client.add("7", "10.0.0.1:9000")
+	peers.mu.Lock()
+	peer, _ := peers.store.Get("7")
+	peer.LastSeen = time.Now().Add(-time.Hour)
+	peers.store.Put("7", peer)
+	peers.mu.Unlock()
+
+	if got := client.send(Request{Type: "PING", Code: "7", Token: token}); got.Type != TypePing {
+		t.Fatalf("PING: got %+v", got)
+	}
+	peer, _ = peers.get("7")
+	if time.Since(peer.LastSeen) > time.Minute {
+		t.Errorf("PING did not refresh LastSeen")
+	}
+
+	if got := errorCode(client.send(Request{Type: "PING", Code: "7", Token: "forged"})); got != InvalidToken {
+		t.Errorf("PING with wrong token: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "PING", Code: "8", Token: token})); got != NotFound {
+		t.Errorf("PING for unknown code: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "PING", Code: "7"})); got != InvalidToken {
+		t.Errorf("PING without token: got error %d", got)
+	}
+}
+
+func TestDel(t *testing.T) {
+	peers := newPeers()
+	client := dialServer(t, startServer(t, peers, nil))
+
+	token := client.add("7", "10.0.0.1:9000")
+	if got := errorCode(client.send(Request{Type: "DEL", Code: "7", Token: "forged"})); got != InvalidToken {
+		t.Errorf("DEL with wrong token: got error %d", got)
+	}
+	if got := client.send(Request{Type: "DEL", Code: "7", Token: token}); got.Type != TypeDel {
+		t.Errorf("DEL: got %+v", got)
+	}
+	if got := errorCode(client.send(Request{Type: "LOOK", Code: "7"})); got != NotFound {
+		t.Errorf("LOOK after DEL: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "DEL", Code: "7", Token: token})); got != NotFound {
+		t.Errorf("second DEL: got error %d", got)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	peers := newPeers()
+	client := dialServer(t, startServer(t, peers, nil))
+	token := client.add("7", "10.0.0.1:9000")
+	if err := peers.Revoke("7"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := errorCode(client.send(Request{Type: "LOOK", Code: "7"})); got != NotFound {
+		t.Errorf("LOOK of a revoked code: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "PING", Code: "7", Token: token})); got != Revoked {
+		t.Errorf("PING of a revoked code: got error %d", got)
+	}
+	// The owner cannot bring the code back, and nobody else can take it.
+	if got := errorCode(client.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.1:9000", Token: token})); got != Revoked {
+		t.Errorf("ADD by the owner of a revoked code: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.2:9000"})); got != CodeInUse {
+		t.Errorf("ADD of a revoked code: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "DEL", Code: "7", Token: token})); got != Revoked {
+		t.Errorf("DEL of a revoked code: got error %d", got)
+	}
+	if got := errorCode(client.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.1:9000", Token: token})); got != Revoked {
+		t.Errorf("ADD by the owner after trying to delete a revoked code: got error %d", got)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var events []string
+	var mu sync.Mutex
+	record := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+	server := NewServer(newPeers())
+	server.Hooks = Hooks{
+		OnRequest:  func(typ string) { record("request %s", typ) },
+		OnError:    func(err *Error) { record("error %d", err.Code) },
+		OnRegister: func(code string, _ PeerInfo, updated bool) { record("register %s %t", code, updated) },
+		OnLookup:   func(code string, found bool) { record("lookup %s %t", code, found) },
+		OnDelete:   func(code string) { record("delete %s", code) },
+	}
+	client := dialServer(t, serve(t, server))
+	token := client.add("7", "10.0.0.1:9000")
+	client.send(Request{Type: "LOOK", Code: "7"})
+	client.send(Request{Type: "LOOK", Code: "8"})
+	client.send(Request{Type: "DEL", Code: "7", Token: token})
+
+	want := []string{
+		"request HELLO",
+		"request ADD", "register 7 false",
+		"request LOOK", "lookup 7 true",
+		"request LOOK", "lookup 8 false", "error 404",
+		"request DEL", "delete 7",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("hooks saw %q, expected %q", events, want)
+	}
+}
+
+func TestLimitedUses(t *testing.T) {
+	peers := newPeers()
+	addr := startServer(t, peers, nil)
+	owner := dialServer(t, addr)
+	other := dialServer(t, addr)
+
+	token := owner.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.1:9000", Uses: 1}).Token
+	// Looking the code up does not use it, only a completed download does.
+	for i := 0; i < 2; i++ {
+		if got := other.send(Request{Type: "LOOK", Code: "7"}); got.Address != "10.0.0.1:9000" {
+			t.Fatalf("LOOK before the download: got %+v", got)
+		}
+	}
+	if got := owner.send(Request{Type: "PING", Code: "7", Token: token, Downloads: 1}); got.Type != TypePing {
+		t.Fatalf("PING reporting the download: got %+v", got)
+	}
+	if got := errorCode(other.send(Request{Type: "LOOK", Code: "7"})); got != NotFound {
+		t.Errorf("LOOK after the only use: got error %d", got)
+	}
+
+	// The used up code still belongs to its owner.
+	if got := errorCode(other.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.66:9000"})); got != CodeInUse {
+		t.Errorf("ADD of a used up code: got error %d", got)
+	}
+	if got := owner.send(Request{Type: "PING", Code: "7", Token: token}); got.Type != TypePing {
+		t.Errorf("PING of a used up code: got %+v", got)
+	}
+	owner.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.2:9000", Token: token, Uses: 1})
+	if got := errorCode(other.send(Request{Type: "LOOK", Code: "7"})); got != NotFound {
+		t.Errorf("updating the address reset the uses: LOOK returned error %d", got)
+	}
+}
+
+func TestExpires(t *testing.T) {
+	peers := newPeers()
+	client := dialServer(t, startServer(t, peers, nil))
+
+	client.send(Request{Type: "ADD", Code: "7", Address: "10.0.0.1:9000", Expires: time.Now().Add(-time.Minute)})
+	client.send(Request{Type: "ADD", Code: "8", Address: "10.0.0.2:9000", Expires: time.Now().Add(time.Hour)})
+
+	if got := errorCode(client.send(Request{Type: "LOOK", Code: "7"})); got != NotFound {
+		t.Errorf("LOOK of an expired code: got error %d", got)
+	}
+	if got := client.send(Request{Type: "LOOK", Code: "8"}); got.Address != "10.0.0.2:9000" {
+		t.Errorf("LOOK of a code that has not expired: got %+v", got)
+	}
+}
+
+func TestGC(t *testing.T) {
+	peers := newPeers()
+	peers.Add("stale", PeerInfo{Address: "10.0.0.1:9000"})
+	alive, _ := peers.Add("alive", PeerInfo{Address: "10.0.0.2:9000"})
+
+	server := NewServer(peers)
+	server.GCInterval, server.PeerTTL = 10*time.Millisecond, 100*time.Millisecond
+	serve(t, server)
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if err := peers.Refresh("alive", alive, 0); err != nil {
+			t.Fatalf("refreshed registration was collected: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, exists := peers.get("stale"); exists {
+		t.Errorf("stale registration was not collected")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.log")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(NewPeers(store))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	client := dialServer(t, listener.Addr().String())
+	client.add("7", "10.0.0.1:9000")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve returned %v, expected ErrServerClosed", err)
+	}
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Errorf("idle client was not disconnected")
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Errorf("server still accepts connections")
+	}
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, exists := store.Get("7"); !exists {
+		t.Errorf("registration was not persisted")
+	}
+}
+
+func TestShutdownWaitsForRelay(t *testing.T) {
+	peers := newPeers()
+	server := NewServer(peers)
+	server.Relay = NewRelay(0, time.Minute, time.Minute)
+	addr := serve(t, server)
+
+	sharer := dialServer(t, addr)
+	token := sharer.add("7", "10.0.0.1:9000")
+	Write(sharer.conn, Request{Type: TypeRelay, Code: "7", Token: token, Role: RoleShare})
+	receiver := dialServer(t, addr)
+	if got := receiver.send(Request{Type: TypeRelay, Code: "7"}); got.Type != TypeRelay {
+		t.Fatalf("RELAY: got %+v", got)
+	}
+	sharer.read()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(ctx) }()
+
+	// The relayed session keeps working while the server drains.
+	time.Sleep(20 * time.Millisecond)
+	fmt.Fprintln(receiver.conn, "still relayed")
+	if line, err := sharer.reader.ReadString('\n'); err != nil || line != "still relayed\n" {
+		t.Errorf("relayed session was cut during shutdown: %q, %v", line, err)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown returned %v, expected the deadline to pass", err)
+	}
+	if _, err := sharer.reader.ReadByte(); err == nil {
+		t.Errorf("relayed session survived the shutdown deadline")
+	}
+}
+
+//TODO: func TestPeersConcurrency(t *testing.T) {
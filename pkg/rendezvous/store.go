@@ -0,0 +1,229 @@
+package rendezvous
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps the registrations of a Peers. Peers serializes every call, so
+// implementations need not be safe for concurrent use.
+type Store interface {
+	Get(code string) (PeerInfo, bool)
+	Put(code string, peer PeerInfo) error
+	Delete(code string) error
+	// Range calls fn for each registration until fn returns false.
+	Range(fn func(code string, peer PeerInfo) bool)
+	Close() error
+}
+
+// MemoryStore keeps registrations in a map, so they are lost on restart.
+type MemoryStore struct {
+	peers map[string]PeerInfo
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{peers: make(map[string]PeerInfo)}
+}
+
+func (m *MemoryStore) Get(code string) (PeerInfo, bool) {
+	peer, exist := m.peers[code]
+	return peer, exist
+}
+
+func (m *MemoryStore) Put(code string, peer PeerInfo) error {
+	m.peers[code] = peer
+	return nil
+}
+
+func (m *MemoryStore) Delete(code string) error {
+	delete(m.peers, code)
+	return nil
+}
+
+func (m *MemoryStore) Range(fn func(code string, peer PeerInfo) bool) {
+	for code, peer := range m.peers {
+		if !fn(code, peer) {
+			return
+		}
+	}
+}
+
+// Len returns the number of registrations.
+func (m *MemoryStore) Len() int {
+	return len(m.peers)
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore keeps registrations in memory and appends every change to a log
+// file, which is replayed when the store is opened again so registrations
+// survive a restart. The log is compacted on open and whenever it grows well
+// past the number of live registrations.
+type FileStore struct {
+	*MemoryStore
+	path    string
+	file    *os.File
+	records int // records in the log, live or not
+}
+
+// logRecord is one line of the FileStore log. The token is kept beside the
+// peer because PeerInfo does not marshal it.
+type logRecord struct {
+	Op    string    `json:"op"` // "put" or "del"
+	Code  string    `json:"code"`
+	Peer  *PeerInfo `json:"peer,omitempty"`
+	Token string    `json:"token,omitempty"`
+}
+
+// OpenFileStore opens the log at path, creating it if needed, and loads the
+// registrations recorded in it.
+func OpenFileStore(path string) (*FileStore, error) {
+	f := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileStore) load() error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening store: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A last line without a newline was cut short by a crash.
+			if len(data) > 0 {
+				slog.Warn("Ignoring truncated record in store", "path", f.path, "line", line)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading store: %w", err)
+		}
+		var rec logRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("reading store: line %d: %w", line, err)
+		}
+		switch {
+		case rec.Op == "put" && rec.Peer != nil:
+			rec.Peer.Token = rec.Token
+			f.peers[rec.Code] = *rec.Peer
+		case rec.Op == "del":
+			delete(f.peers, rec.Code)
+		default:
+			return fmt.Errorf("reading store: line %d: unknown record %q", line, rec.Op)
+		}
+	}
+}
+
+// compact rewrites the log with only the live registrations and reopens it
+// for appending. The new log replaces the old one atomically.
+func (f *FileStore) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("compacting store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for code, peer := range f.peers {
+		if err := writeRecord(writer, putRecord(code, peer)); err != nil {
+			tmp.Close()
+			return fmt.Errorf("compacting store: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compacting store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compacting store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("compacting store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("compacting store: %w", err)
+	}
+
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("opening store: %w", err)
+	}
+	f.records = len(f.peers)
+	return nil
+}
+
+func putRecord(code string, peer PeerInfo) logRecord {
+	return logRecord{Op: "put", Code: code, Peer: &peer, Token: peer.Token}
+}
+
+func writeRecord(w io.Writer, rec logRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (f *FileStore) append(rec logRecord) error {
+	if err := writeRecord(f.file, rec); err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	f.records++
+	if f.records > 2*len(f.peers)+64 {
+		return f.compact()
+	}
+	return nil
+}
+
+func (f *FileStore) Put(code string, peer PeerInfo) error {
+	f.peers[code] = peer
+	return f.append(putRecord(code, peer))
+}
+
+func (f *FileStore) Delete(code string) error {
+	if _, exist := f.peers[code]; !exist {
+		return nil
+	}
+	delete(f.peers, code)
+	return f.append(logRecord{Op: "del", Code: code})
+}
+
+// Close compacts the log, so the next start has the least to replay, and
+// closes it.
+func (f *FileStore) Close() error {
+	if err := f.compact(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("closing store: %w", err)
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,100 @@
+package rendezvous
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.log")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := NewPeers(store)
+	token, err := peers.Add("7", PeerInfo{Address: "10.0.0.1:9000", MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, _ := peers.Add("8", PeerInfo{Address: "10.0.0.2:9000"})
+	peers.Look("7")
+	if err := peers.Delete("8", gone); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	peers = NewPeers(store)
+
+	peer, exists := peers.get("7")
+	if !exists || peer.Address != "10.0.0.1:9000" || peer.Lookups != 1 || peer.MaxUses != 2 {
+		t.Errorf("registration not restored: %+v, %v", peer, exists)
+	}
+	if err := peers.Refresh("7", token, 0); err != nil {
+		t.Errorf("owner token not restored: %v", err)
+	}
+	if _, exists := peers.get("8"); exists {
+		t.Errorf("deleted registration was restored")
+	}
+}
+
+func TestFileStoreIgnoresTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.log")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("7", PeerInfo{Address: "10.0.0.1:9000", LastSeen: time.Now()})
+	store.Close()
+
+	// Simulate a crash in the middle of writing a record.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","code":"8","peer":{"addr`)
+	f.Close()
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	defer store.Close()
+	if _, exists := store.Get("7"); !exists {
+		t.Errorf("complete record was lost")
+	}
+	if _, exists := store.Get("8"); exists {
+		t.Errorf("truncated record was loaded")
+	}
+}
+
+func TestFileStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.log")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 1000; i++ {
+		if err := store.Put("7", PeerInfo{Address: "10.0.0.1:9000", LastSeen: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines > 100 {
+		t.Errorf("log holds %d records for one registration", lines)
+	}
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ServerTLSConfig builds the TLS config for the rendezvous server. The
+// certificate is loaded from certFile/keyFile; with selfSigned a certificate
+// is generated instead and, when file paths are given, saved there so its
+// fingerprint stays the same across restarts.
+func ServerTLSConfig(certFile, keyFile string, selfSigned bool) (*tls.Config, error) {
+	if selfSigned {
+		if certFile != "" && keyFile != "" {
+			if _, err := os.Stat(certFile); err == nil {
+				return loadServerTLSConfig(certFile, keyFile)
+			}
+		}
+		cert, certPEM, keyPEM, err := GenerateSelfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		if certFile != "" && keyFile != "" {
+			if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+				return nil, fmt.Errorf("failed to save certificate: %w", err)
+			}
+			if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+				return nil, fmt.Errorf("failed to save key: %w", err)
+			}
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	}
+	return loadServerTLSConfig(certFile, keyFile)
+}
+
+func loadServerTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// GenerateSelfSignedCert creates an ECDSA certificate valid for this host and
+// returns it along with its PEM encoded certificate and key.
+func GenerateSelfSignedCert() (tls.Certificate, []byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "DirectDrop rendezvous server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP(GetDeviceIP())},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, nil, nil, err
+	}
+	return cert, certPEM, keyPEM, nil
+}
+
+// CertFingerprint returns the hex encoded SHA256 of a DER certificate, the
+// value clients pin with -Fingerprint.
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint lowercases a fingerprint and strips the colons some
+// tools print between bytes.
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// ClientTLSConfig builds the TLS config used to reach the rendezvous server.
+// Without a fingerprint the server certificate is verified against the system
+// roots; with one, only a certificate with that exact fingerprint is accepted,
+// which is how self-signed servers are trusted.
+func ClientTLSConfig(fingerprint string) *tls.Config {
+	if fingerprint == "" {
+		return &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	pinned := NormalizeFingerprint(fingerprint)
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Chain verification is replaced by the fingerprint check below.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			if got := CertFingerprint(rawCerts[0]); got != pinned {
+				return fmt.Errorf("server certificate fingerprint %s does not match pinned %s", got, pinned)
+			}
+			return nil
+		},
+	}
+}
+
+// DialServer connects to the rendezvous server at addr, over TLS when
+// tlsConfig is set.
+func DialServer(addr string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig == nil {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig)
+}
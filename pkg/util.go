@@ -3,16 +3,20 @@ package pkg
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
+	"math/big"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"unsafe"
@@ -20,11 +24,17 @@ import (
 
 const CHARSET = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// GenerateRandomString returns a string drawn from CHARSET using crypto/rand,
+// so it is safe to use for secrets.
 func GenerateRandomString(length int) string {
-	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
 	b := make([]byte, length)
+	max := big.NewInt(int64(len(CHARSET)))
 	for i := range b {
-		b[i] = CHARSET[seededRand.Intn(len(CHARSET))]
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(fmt.Sprintf("crypto/rand failed: %v", err))
+		}
+		b[i] = CHARSET[n.Int64()]
 	}
 	return string(b)
 }
@@ -34,10 +44,68 @@ func HandleFlags() []*string {
 	code := flag.String("Code", "", "a unique code which will be send to server.")
 	action := flag.String("Action", "", "Whether you want to share or receive a file/folder")
 	path := flag.String("Path", "", "Address of file/folder")
+	useTLS := boolFlag("TLS", "Connect to the server over TLS")
+	fingerprint := flag.String("Fingerprint", "", "SHA256 fingerprint of a self-signed server certificate to pin (implies -TLS)")
+	port := flag.String("Port", "0", "Port the sharer listens on, 0 picks a free one")
+	qr := boolFlag("QR", "Also print the share code as a QR code")
+	asJSON := boolFlag("JSON", "Print the share code as JSON for scripts")
+	words := flag.String("Words", "2", "Number of words in the share code, each adds 8 bits of entropy")
+	uses := flag.String("Uses", "0", "Close the share after this many downloads, 0 for unlimited")
+	expires := flag.String("Expires", "", "Close the share after a duration such as 30m, or at an RFC 3339 time")
+	metricsAddr := flag.String("Metrics", "", "Serve Prometheus metrics of the transfer on <IP>:<Port>/metrics")
+	yes := boolFlag("Yes", "Accept the share without asking for confirmation")
+	out := flag.String("Out", "download", "Directory received files and folders are written to")
+	onConflict := flag.String("OnConflict", "rename", "What to do when a received file exists: overwrite, skip, rename or ask")
+	noPreserve := boolFlag("NoPreserve", "Do not restore the file modes and modification times of received files")
 
 	flag.Parse()
 
-	return []*string{serverAddr, code, action, path}
+	return []*string{serverAddr, code, action, path, useTLS, fingerprint, port, qr, asJSON, words, uses, expires, metricsAddr, yes, out, onConflict, noPreserve}
+}
+
+// boolValue is a flag.Value that stores "true" or "false" in a *string so
+// boolean flags fit the slice returned by HandleFlags while still being
+// usable without an explicit value, e.g. "-TLS".
+type boolValue struct{ value *string }
+
+func (b boolValue) String() string {
+	if b.value == nil {
+		return "false"
+	}
+	return *b.value
+}
+
+func (b boolValue) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.value = strconv.FormatBool(v)
+	return nil
+}
+
+func (b boolValue) IsBoolFlag() bool { return true }
+
+func boolFlag(name, usage string) *string {
+	value := "false"
+	flag.Var(boolValue{&value}, name, usage)
+	return &value
+}
+
+// Enabled reports whether a boolean flag returned by HandleFlags is set.
+func Enabled(flag *string) bool {
+	v, _ := strconv.ParseBool(*flag)
+	return v
+}
+
+// ServerTLS returns the TLS config for reaching the rendezvous server as
+// requested by the flags, or nil for plain TCP.
+func ServerTLS(flags []*string) *tls.Config {
+	fingerprint := *flags[5]
+	if !Enabled(flags[4]) && fingerprint == "" {
+		return nil
+	}
+	return ClientTLSConfig(fingerprint)
 }
 
 func Validate(flags []*string) bool {
@@ -46,7 +114,8 @@ func Validate(flags []*string) bool {
 		return false
 	}
 
-	serverAddr, code, action, path := flags[0], flags[1], flags[2], flags[3]
+	serverAddr, code, action, path, fingerprint, port, words := flags[0], flags[1], flags[2], flags[3], flags[5], flags[6], flags[9]
+	uses, expires, metricsAddr := flags[10], flags[11], flags[12]
 
 	// Validate action
 	if *action != "share" && *action != "receive" {
@@ -60,6 +129,20 @@ func Validate(flags []*string) bool {
 		return false
 	}
 
+	if *fingerprint != "" {
+		if _, err := hex.DecodeString(NormalizeFingerprint(*fingerprint)); err != nil || len(NormalizeFingerprint(*fingerprint)) != 2*sha256.Size {
+			log.Fatal("Fingerprint must be a hex encoded SHA256 digest")
+			return false
+		}
+	}
+
+	if *metricsAddr != "" {
+		if _, _, err := net.SplitHostPort(*metricsAddr); err != nil {
+			log.Fatal("Metrics address must be in format 'host:port'")
+			return false
+		}
+	}
+
 	// Action-specific validation
 	switch *action {
 	case "share":
@@ -72,16 +155,58 @@ func Validate(flags []*string) bool {
 			log.Fatalf("Path '%s' does not exist", *path)
 			return false
 		}
+		if p, err := strconv.Atoi(*port); err != nil || p < 0 || p > 65535 {
+			log.Fatal("Port must be a number between 0 and 65535")
+			return false
+		}
+		if n, err := strconv.Atoi(*words); err != nil || n < 2 || n > 8 {
+			log.Fatal("Words must be a number between 2 and 8")
+			return false
+		}
+		if n, err := strconv.Atoi(*uses); err != nil || n < 0 {
+			log.Fatal("Uses must be a number, 0 for unlimited")
+			return false
+		}
+		if t, err := ParseExpiry(*expires); err != nil {
+			log.Fatal(err)
+			return false
+		} else if !t.IsZero() && t.Before(time.Now()) {
+			log.Fatal("Expires must be in the future")
+			return false
+		}
 	case "receive":
 		if *code == "" {
 			log.Fatal("Code is required for receive action")
 			return false
 		}
+		normalized, err := NormalizeCode(*code)
+		if err != nil {
+			log.Fatal(err)
+			return false
+		}
+		*code = normalized
 	}
 
 	return true
 }
 
+// ParseExpiry parses the -Expires flag, either a duration from now such as
+// "30m" or an RFC 3339 time. An empty value means the share never expires and
+// returns the zero time.
+func ParseExpiry(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Expires must be a duration such as 30m or an RFC 3339 time, got %q", value)
+	}
+	return t, nil
+}
+
 // calculateChecksum calculates SHA256 checksum of a file
 func CalculateChecksum(file *os.File) (string, error) {
 	hash := sha256.New()
@@ -164,16 +289,90 @@ func SendMetadata(conn net.Conn, meta interface{}) error {
 }
 
 func WaitAck(conn net.Conn) error {
-	ack := make([]byte, 3)
-	if _, err := conn.Read(ack); err != nil {
-		return fmt.Errorf("failed to read ack: %w", err)
+	offset, err := ReadAck(conn)
+	if err != nil {
+		return err
 	}
-	if string(ack) != "OK\n" {
-		return fmt.Errorf("unexpected ack: %q", string(ack))
+	if offset != 0 {
+		return fmt.Errorf("unexpected resume request at offset %d", offset)
 	}
 	return nil
 }
 
+// ErrRefused is returned by ReadAck when the receiver answered with an
+// ERROR line instead of accepting the object.
+var ErrRefused = errors.New("receiver refused")
+
+// ErrSkipped is returned by ReadAck when the receiver answered SKIP because it
+// keeps its own copy of the file.
+var ErrSkipped = errors.New("receiver skipped the file")
+
+// maxAckLine bounds an ack line, leaving room for the reason of a refusal.
+const maxAckLine = 512
+
+// ReadAck reads the receiver's reply to a file announcement. "OK" asks for
+// the whole file, "RESUME <offset>" asks to continue from the given byte
+// offset and the offset is returned. "SKIP" declines the file and is returned
+// as ErrSkipped, "ERROR <reason>" refuses the object and is returned as
+// ErrRefused.
+func ReadAck(conn net.Conn) (int64, error) {
+	line, err := ReadLine(conn, maxAckLine)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read ack: %w", err)
+	}
+	if line == "OK" {
+		return 0, nil
+	}
+	if line == "SKIP" {
+		return 0, ErrSkipped
+	}
+	if reason, ok := strings.CutPrefix(line, "ERROR "); ok {
+		return 0, fmt.Errorf("%w: %s", ErrRefused, reason)
+	}
+	if rest, ok := strings.CutPrefix(line, "RESUME "); ok {
+		offset, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("invalid resume offset: %q", rest)
+		}
+		return offset, nil
+	}
+	return 0, fmt.Errorf("unexpected ack: %q", line)
+}
+
+// SendRefusal answers an announcement with an ERROR line carrying reason, in
+// place of the ack.
+func SendRefusal(conn net.Conn, reason string) error {
+	reason = strings.Join(strings.Fields(reason), " ")
+	if len(reason) > maxAckLine-len("ERROR ") {
+		reason = reason[:maxAckLine-len("ERROR ")]
+	}
+	if _, err := conn.Write([]byte("ERROR " + reason + "\n")); err != nil {
+		return fmt.Errorf("failed to send refusal: %w", err)
+	}
+	return nil
+}
+
+// ReadLine reads a single '\n' terminated line one byte at a time so nothing
+// past the line is consumed from conn.
+func ReadLine(conn net.Conn, max int) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < max {
+		n, err := conn.Read(b)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			continue
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", fmt.Errorf("line exceeds %d bytes", max)
+}
+
 func VerifyChecksum(data []byte, expected string) error {
 	sum := sha256.Sum256(data)
 	got := hex.EncodeToString(sum[:])
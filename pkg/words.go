@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed wordlist.txt
+var wordlistFile string
+
+// Wordlist holds the sorted words share codes are built from. Every word has a
+// unique three letter prefix, so typing three letters is always enough to
+// complete one.
+var Wordlist = strings.Fields(wordlistFile)
+
+// MAX_NAMEPLATE is the largest number used as the public part of a code.
+// Servers hand out the lowest free number, so codes stay short while leaving
+// room for many shares at once.
+const MAX_NAMEPLATE = 999999
+
+// GenerateCode returns a new share code such as "7-apple-river": a number
+// that names the share on the rendezvous server followed by words random
+// words, each adding 8 bits to the secret. Only the number is sent to the
+// server; the words never leave the two peers and key the end-to-end
+// encryption.
+func GenerateCode(words int) string {
+	return GenerateNameplate() + "-" + GenerateSecret(words)
+}
+
+// GenerateNameplate returns a random nameplate, for servers that do not
+// allocate one.
+func GenerateNameplate() string {
+	return strconv.FormatInt(randomInt(MAX_NAMEPLATE)+1, 10)
+}
+
+// GenerateSecret returns the secret part of a share code: words random words
+// joined by dashes.
+func GenerateSecret(words int) string {
+	parts := make([]string, words)
+	for i := range parts {
+		parts[i] = Wordlist[randomInt(int64(len(Wordlist)))]
+	}
+	return strings.Join(parts, "-")
+}
+
+func randomInt(max int64) int64 {
+	n, err := rand.Int(rand.Reader, big.NewInt(max))
+	if err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return n.Int64()
+}
+
+// SplitCode splits a share code into its nameplate and secret.
+func SplitCode(code string) (nameplate, secret string, err error) {
+	nameplate, secret, ok := strings.Cut(code, "-")
+	if !ok || nameplate == "" || secret == "" {
+		return "", "", fmt.Errorf("invalid code %q: expected <number>-<word>-<word>", code)
+	}
+	return nameplate, secret, nil
+}
+
+// CompleteWord returns the words in Wordlist that start with prefix.
+func CompleteWord(prefix string) []string {
+	prefix = strings.ToLower(prefix)
+	i := sort.SearchStrings(Wordlist, prefix)
+	j := i
+	for j < len(Wordlist) && strings.HasPrefix(Wordlist[j], prefix) {
+		j++
+	}
+	return Wordlist[i:j]
+}
+
+// NormalizeCode checks a code typed by a receiver and returns it in canonical
+// form. Case is ignored, spaces may be used instead of dashes and every word
+// may be shortened to any prefix that matches a single word, so "7 app riv"
+// becomes "7-apple-river".
+func NormalizeCode(code string) (string, error) {
+	parts := strings.FieldsFunc(strings.ToLower(code), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t'
+	})
+	if len(parts) < 2 {
+		return "", fmt.Errorf("invalid code %q: expected <number>-<word>-<word>", code)
+	}
+	if n, err := strconv.Atoi(parts[0]); err != nil || n < 1 || n > MAX_NAMEPLATE {
+		return "", fmt.Errorf("invalid code %q: %q is not a number between 1 and %d", code, parts[0], MAX_NAMEPLATE)
+	}
+
+	for i, word := range parts[1:] {
+		matches := CompleteWord(word)
+		switch {
+		case len(matches) == 0:
+			return "", fmt.Errorf("invalid code %q: unknown word %q", code, word)
+		case len(matches) > 1 && matches[0] != word:
+			return "", fmt.Errorf("invalid code %q: %q could be any of %s", code, word, strings.Join(matches, ", "))
+		}
+		parts[i+1] = matches[0]
+	}
+	return strings.Join(parts, "-"), nil
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWordlistPrefixes(t *testing.T) {
+	if len(Wordlist) != 256 {
+		t.Fatalf("expected 256 words, got %d", len(Wordlist))
+	}
+	seen := make(map[string]string)
+	for i, word := range Wordlist {
+		if i > 0 && Wordlist[i-1] >= word {
+			t.Errorf("wordlist not sorted at %q", word)
+		}
+		if other, ok := seen[word[:3]]; ok {
+			t.Errorf("%q and %q share a prefix", other, word)
+		}
+		seen[word[:3]] = word
+	}
+}
+
+func TestGenerateCode(t *testing.T) {
+	for words := 2; words <= 8; words++ {
+		code := GenerateCode(words)
+		if got := strings.Count(code, "-"); got != words {
+			t.Errorf("GenerateCode(%d) = %q, expected %d words", words, code, words)
+		}
+		normalized, err := NormalizeCode(code)
+		if err != nil || normalized != code {
+			t.Errorf("NormalizeCode(%q) = %q, %v", code, normalized, err)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      string
+	}{
+		{in: "7-apple-zebra", err: "unknown word"},
+		{in: "7-apple-zoo", want: "7-apple-zoo"},
+		{in: "7 APP zo", err: "could be any of zone, zoo"},
+		{in: "42 app zon", want: "42-apple-zone"},
+		{in: "0-apple-zoo", err: "not a number"},
+		{in: "abc-apple", err: "not a number"},
+		{in: "12", err: "expected"},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeCode(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("NormalizeCode(%q): expected error containing %q, got %v", tt.in, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeCode(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}